@@ -0,0 +1,48 @@
+// Command fakeaccrual runs the fake accrual system for local runs of gophermart. Orders
+// are scripted through the admin API, see package fakeaccrual.
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/fakeaccrual"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func main() {
+	address := flag.String("a", cmp.Or(os.Getenv("RUN_ADDRESS"), "localhost:8080"), "Адрес запуска сервиса")
+	rateLimit := flag.Int("rate-limit", 0, "Количество запросов информации о заказах в минуту, сверх которого отвечать 429, 0 — без ограничения")
+	retryAfter := flag.Duration("retry-after", 0, "Значение Retry-After в ответах 429, 0 — время до конца текущей минуты")
+	latency := flag.Duration("latency", 0, "Задержка ответов на запросы информации о заказах")
+	flag.Parse()
+
+	fake := fakeaccrual.New(fakeaccrual.Options{RateLimit: *rateLimit, RetryAfter: *retryAfter, Latency: *latency})
+	server := &http.Server{Addr: *address, Handler: middleware.Logger(fake)}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Starting fake accrual system", slog.String("address", *address))
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Server stopped with an error", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/config"
+	"github.com/atinyakov/go-musthave-diploma/pkg/auth"
+)
+
+// devJWTSecret signs tokens in dev mode when no secret is configured
+const devJWTSecret = "my_secret_key"
+
+var ErrNoJWTSecret = errors.New("JWT_SECRET or JWT_KEY_FILE is required outside dev mode")
+
+// newJWT builds the token issuer from the configured signing key and the keys it replaced
+func newJWT(config *config.Config) (*auth.JWT, error) {
+	var signing auth.Key
+	var err error
+
+	switch {
+	case config.JWTKeyFile != "":
+		signing, err = auth.LoadKey(config.JWTKeyID, config.JWTKeyFile)
+	case config.JWTSecret != "":
+		signing, err = auth.NewHMACKey(config.JWTKeyID, []byte(config.JWTSecret))
+	case config.DevMode:
+		slog.Warn("JWT secret is not set, using the insecure dev secret")
+		signing, err = auth.NewHMACKey(config.JWTKeyID, []byte(devJWTSecret))
+	default:
+		return nil, ErrNoJWTSecret
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if signing.Algorithm() == "HS256" && config.JWTSecret != "" && len(config.JWTSecret) < 32 {
+		slog.Warn("JWT secret is shorter than 32 bytes")
+	}
+
+	var previous []auth.Key
+	for _, entry := range strings.Split(config.JWTPreviousKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, path, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("%w: previous key %q must be kid=file", auth.ErrInvalidKey, entry)
+		}
+
+		key, err := auth.LoadKey(id, path)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return auth.NewJWT(config.JWTTTL, signing, previous...)
+}
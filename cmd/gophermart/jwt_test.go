@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/config"
+	"github.com/atinyakov/go-musthave-diploma/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewJWT(t *testing.T) {
+	t.Run("secret is required outside dev mode", func(t *testing.T) {
+		_, err := newJWT(&config.Config{JWTKeyID: "1", JWTTTL: time.Hour})
+		assert.ErrorIs(t, err, ErrNoJWTSecret)
+	})
+
+	t.Run("dev mode", func(t *testing.T) {
+		j, err := newJWT(&config.Config{DevMode: true, JWTKeyID: "1", JWTTTL: time.Hour})
+		require.NoError(t, err)
+
+		token, err := j.Generate("testuser", "s1")
+		require.NoError(t, err)
+		_, err = j.Parse(token)
+		assert.NoError(t, err)
+	})
+
+	t.Run("previous keys", func(t *testing.T) {
+		dir := t.TempDir()
+		oldPath := filepath.Join(dir, "old.key")
+		require.NoError(t, os.WriteFile(oldPath, []byte("old secret\n"), 0o600))
+
+		oldKey, err := auth.NewHMACKey("old", []byte("old secret"))
+		require.NoError(t, err)
+		old, err := auth.NewJWT(time.Hour, oldKey)
+		require.NoError(t, err)
+		oldToken, err := old.Generate("testuser", "s1")
+		require.NoError(t, err)
+
+		j, err := newJWT(&config.Config{
+			JWTSecret:       "0123456789abcdef0123456789abcdef",
+			JWTKeyID:        "new",
+			JWTPreviousKeys: "old=" + oldPath,
+			JWTTTL:          time.Hour,
+		})
+		require.NoError(t, err)
+
+		claims, err := j.Parse(oldToken)
+		require.NoError(t, err)
+		assert.Equal(t, "testuser", claims.Username)
+	})
+
+	t.Run("malformed previous keys", func(t *testing.T) {
+		_, err := newJWT(&config.Config{JWTSecret: "secret", JWTKeyID: "1", JWTPreviousKeys: "old", JWTTTL: time.Hour})
+		assert.ErrorIs(t, err, auth.ErrInvalidKey)
+	})
+}
@@ -2,41 +2,152 @@ package main
 
 import (
 	"context"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/config"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/breaker"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/election"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
-	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/ledger"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/loginlimit"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/server"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/service"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/worker"
-	"github.com/atinyakov/go-musthave-diploma/internal/db"
+	"github.com/atinyakov/go-musthave-diploma/pkg/middleware"
 )
 
+// accrualLeaderLock identifies the advisory lock held by the replica polling the accrual system
+const accrualLeaderLock int64 = 7001
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	os.Exit(runServer())
+}
+
+// runServer serves the API until SIGINT or SIGTERM and returns the exit code
+func runServer() int {
 	config := config.LoadConfig()
-	db := db.InitDB(config.DatabaseURI)
+
+	tokens, err := newJWT(config)
+	if err != nil {
+		slog.Error("Failed to set up JWT", slog.String("error", err.Error()))
+		return exitError
+	}
+
+	st, err := openStorage(config)
+	if err != nil {
+		slog.Error("Failed to open storage", slog.String("error", err.Error()))
+		return exitError
+	}
+
 	client := client.New(config.AccrualSystemAddress + "/api/orders/")
+	breaker := breaker.New(client, breaker.Options{
+		FailureThreshold: config.BreakerThreshold,
+		OpenTimeout:      config.BreakerOpenTimeout,
+		HalfOpenRequests: config.BreakerHalfOpen,
+	})
+
+	repository := st.repo
+	worker := worker.NewAccrualTaskWorker(repository, breaker, worker.Options{
+		Workers:             config.AccrualWorkers,
+		UnregisteredTimeout: config.UnregisteredTimeout,
+	})
 
-	repository := repository.New(db)
-	worker := worker.NewAccrualTaskWorker(repository, client)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	fetcher := worker.StartOrderFetcher
+	if config.LeaderElection && st.db != nil {
+		election := election.New(st.db, accrualLeaderLock, 5*time.Second)
+		fetcher = func(ctx context.Context) {
+			election.Run(ctx, worker.StartOrderFetcher)
+		}
+	} else if config.LeaderElection {
+		slog.Warn("Leader election needs PostgreSQL and is disabled with the in-memory storage")
+	}
 
-	go worker.StartOrderFetcher(ctx)
+	tasks := []func(context.Context){fetcher}
+	var closers []io.Closer
 
-	slog.Info("workers created")
+	// The ledger reconciler checks PostgreSQL balances, in-memory balances have no journal
+	if st.db != nil {
+		ledger := ledger.New(st.db)
+		tasks = append(tasks, func(ctx context.Context) {
+			ledger.StartReconciler(ctx, config.ReconcileInterval)
+		})
+		closers = append(closers, st.db)
+	}
 
 	service := service.New(repository)
-	postHandler := handler.NewPost(service)
+	sessions := session.New(repository, tokens, config.JWTTTL, config.RefreshTokenTTL)
+	var loginStore loginlimit.Store
+	switch config.LoginLimitStore {
+	case "postgres":
+		if st.db == nil {
+			slog.Info("Login limits are kept in memory with the in-memory storage")
+			loginStore = loginlimit.NewMemoryStore()
+			break
+		}
+		loginStore = loginlimit.NewPostgresStore(st.db)
+	case "memory":
+		loginStore = loginlimit.NewMemoryStore()
+	default:
+		slog.Error("Unknown login limit store", slog.String("store", config.LoginLimitStore))
+		return exitError
+	}
+	limiter := loginlimit.New(loginStore, loginlimit.Options{
+		LoginAttempts: config.LoginAttempts,
+		IPAttempts:    config.LoginIPAttempts,
+		Lockout:       config.LoginLockout,
+		MaxLockout:    config.LoginMaxLockout,
+	})
+
+	postHandler := handler.NewPost(service, sessions, limiter)
 	getHandler := handler.NewGet(service)
-	r := server.New(postHandler, getHandler)
-	slog.Info("Starting server")
 
-	err := http.ListenAndServe(config.RunAddress, r)
+	var internalHandler server.InternalHandler
+	if config.AccrualWebhookSecret != "" {
+		internalHandler = handler.NewInternal(worker, config.AccrualWebhookSecret)
+	}
+
+	healthHandler := handler.NewHealth(breaker)
+	auth := middleware.NewAuth(tokens, sessions)
+	idempotency := handler.NewIdempotency(repository)
+
+	r := server.New(postHandler, getHandler, internalHandler, healthHandler, auth, idempotency)
+
+	ln, err := net.Listen("tcp", config.RunAddress)
 	if err != nil {
-		panic(err)
+		slog.Error("Failed to listen", slog.String("address", config.RunAddress), slog.String("error", err.Error()))
+		return exitError
 	}
+
+	app := &app{
+		server:          &http.Server{Handler: r},
+		tasks:           tasks,
+		closers:         closers,
+		shutdownTimeout: config.ShutdownTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Starting server", slog.String("address", ln.Addr().String()))
+
+	if err := app.run(ctx, ln); err != nil {
+		slog.Error("Server stopped with an error", slog.String("error", err.Error()))
+		return exitError
+	}
+
+	slog.Info("Server stopped")
+	return exitOK
 }
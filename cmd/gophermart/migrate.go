@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/config"
+	"github.com/atinyakov/go-musthave-diploma/internal/db"
+)
+
+const migrateUsage = `usage: gophermart migrate up|down [N]|status [flags]
+
+  up       apply all pending migrations
+  down     roll back the last N migrations, 1 by default
+  status   list migrations and when they were applied
+
+Flags are the same as for the server, only -d is used.`
+
+// runMigrate handles "gophermart migrate ..." and returns the exit code
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return exitUsage
+	}
+
+	command, args := args[0], args[1:]
+
+	steps := 1
+	if command == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			steps, args = n, args[1:]
+		}
+	}
+	if steps <= 0 {
+		fmt.Fprintf(os.Stderr, "N must be a positive number, got %d\n\n%s\n", steps, migrateUsage)
+		return exitUsage
+	}
+
+	// The remaining arguments are parsed as the server flags
+	os.Args = append([]string{os.Args[0]}, args...)
+	config := config.LoadConfig()
+
+	migrator, err := db.NewMigrator(db.InitDB(config.DatabaseURI))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		fmt.Printf("applied %d migrations\n", n)
+	case "down":
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		fmt.Printf("rolled back %d migrations\n", n)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		printStatus(statuses)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return exitUsage
+	}
+
+	return exitOK
+}
+
+func printStatus(statuses []db.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	_ = w.Flush()
+}
+
+// migrateUp applies pending migrations at startup
+func migrateUp(conn *sql.DB) error {
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err = migrator.Up(ctx)
+	return err
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMigrate_RejectsNonPositiveDownSteps(t *testing.T) {
+	// Rejected before the flags are parsed and the database is connected
+	for _, n := range []string{"0", "-2"} {
+		assert.Equal(t, exitUsage, runMigrate([]string{"down", n}), "down %s", n)
+	}
+}
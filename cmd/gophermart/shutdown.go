@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exit codes of the service
+const (
+	exitOK = 0
+	// exitError means the service failed to start or to stop cleanly
+	exitError = 1
+	// exitUsage means the command line is wrong
+	exitUsage = 2
+)
+
+var ErrShutdownTimeout = errors.New("shutdown timed out")
+
+// app is the running service: the HTTP server, the background tasks and the resources
+// they share
+type app struct {
+	server *http.Server
+	// tasks run until their context is cancelled and finish the work in flight before returning
+	tasks []func(ctx context.Context)
+	// closers are released last, once nothing uses them
+	closers []io.Closer
+	// shutdownTimeout bounds draining HTTP requests and then stopping the tasks
+	shutdownTimeout time.Duration
+}
+
+// run serves ln until ctx is done or the server fails, then shuts down in order: the
+// server stops accepting connections and drains in-flight requests, the tasks are
+// cancelled and awaited, and the closers are closed. Requests may still hand work to the
+// tasks and both use the database, so neither is stopped before the previous step ends.
+func (a *app) run(ctx context.Context, ln net.Listener) error {
+	// Tasks don't inherit ctx: they must keep running while requests are drained
+	tasksCtx, cancelTasks := context.WithCancel(context.Background())
+	defer cancelTasks()
+
+	var wg sync.WaitGroup
+	for _, task := range a.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task(tasksCtx)
+		}()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- a.server.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	case err = <-serveErr:
+		slog.Error("server failed", slog.String("error", err.Error()))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := a.server.Shutdown(shutdownCtx); shutdownErr != nil {
+		err = errors.Join(err, fmt.Errorf("drain http requests: %w", shutdownErr))
+		// Connections still open after the timeout are dropped
+		_ = a.server.Close()
+	}
+	slog.Info("http server stopped")
+
+	cancelTasks()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		slog.Info("background tasks stopped")
+	case <-shutdownCtx.Done():
+		err = errors.Join(err, fmt.Errorf("stop background tasks: %w", ErrShutdownTimeout))
+	}
+
+	for _, c := range a.closers {
+		if closeErr := c.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}
+
+	return err
+}
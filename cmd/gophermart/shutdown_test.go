@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// events records the order of shutdown steps
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = append(e.list, event)
+}
+
+func (e *events) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.list...)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestAppRun_ShutdownOrder(t *testing.T) {
+	var ev events
+
+	requestStarted := make(chan struct{})
+	releaseRequest := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		<-releaseRequest
+		ev.add("request done")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// The task finishes its in-flight work after being cancelled
+	task := func(ctx context.Context) {
+		<-ctx.Done()
+		ev.add("task cancelled")
+		time.Sleep(50 * time.Millisecond)
+		ev.add("task done")
+	}
+
+	a := &app{
+		server: &http.Server{Handler: handler},
+		tasks:  []func(context.Context){task},
+		closers: []io.Closer{closerFunc(func() error {
+			ev.add("db closed")
+			return nil
+		})},
+		shutdownTimeout: 5 * time.Second,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- a.run(ctx, ln)
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		defer res.Body.Close()
+		status <- res.StatusCode
+	}()
+
+	<-requestStarted
+	stop()
+
+	// The task keeps running while the request is drained
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, ev.get())
+
+	close(releaseRequest)
+
+	require.NoError(t, <-runErr)
+	assert.Equal(t, http.StatusOK, <-status)
+	assert.Equal(t, []string{"request done", "task cancelled", "task done", "db closed"}, ev.get())
+
+	_, err = http.Get("http://" + ln.Addr().String())
+	assert.Error(t, err, "no new connections after shutdown")
+}
+
+func TestAppRun_ShutdownTimeout(t *testing.T) {
+	closed := false
+
+	a := &app{
+		server: &http.Server{Handler: http.NotFoundHandler()},
+		tasks: []func(context.Context){func(ctx context.Context) {
+			<-ctx.Done()
+			time.Sleep(time.Second)
+		}},
+		closers: []io.Closer{closerFunc(func() error {
+			closed = true
+			return nil
+		})},
+		shutdownTimeout: 50 * time.Millisecond,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, stop := context.WithCancel(context.Background())
+	stop()
+
+	err = a.run(ctx, ln)
+	assert.ErrorIs(t, err, ErrShutdownTimeout)
+	assert.True(t, closed, "resources are released even if tasks are stuck")
+}
+
+func TestAppRun_ServerError(t *testing.T) {
+	dbErr := errors.New("close failed")
+
+	a := &app{
+		server:          &http.Server{Handler: http.NotFoundHandler()},
+		closers:         []io.Closer{closerFunc(func() error { return dbErr })},
+		shutdownTimeout: time.Second,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+
+	err = a.run(context.Background(), ln)
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, dbErr)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/config"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
+	"github.com/atinyakov/go-musthave-diploma/internal/db"
+)
+
+var ErrUnknownStorage = errors.New("unknown storage")
+
+// storage is where the server keeps its data. db is nil for the in-memory storage, which
+// has no ledger reconciliation, leader election or login limits shared between replicas.
+type storage struct {
+	repo repository.Backend
+	db   *sql.DB
+}
+
+// openStorage connects to the configured storage and migrates the database when enabled
+func openStorage(config *config.Config) (*storage, error) {
+	switch config.Storage {
+	case "postgres":
+		conn := db.InitDB(config.DatabaseURI)
+
+		if config.AutoMigrate {
+			if err := migrateUp(conn); err != nil {
+				_ = conn.Close()
+				return nil, fmt.Errorf("migrate database: %w", err)
+			}
+		}
+
+		return &storage{repo: repository.New(conn), db: conn}, nil
+	case "memory":
+		slog.Warn("Data is kept in memory and is lost on restart")
+		return &storage{repo: repository.NewMemoryRepository()}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStorage, config.Storage)
+	}
+}
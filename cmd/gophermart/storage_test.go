@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/config"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenStorage(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		st, err := openStorage(&config.Config{Storage: "memory"})
+		require.NoError(t, err)
+		assert.IsType(t, &repository.MemoryRepository{}, st.repo)
+		assert.Nil(t, st.db)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := openStorage(&config.Config{Storage: "mysql"})
+		assert.ErrorIs(t, err, ErrUnknownStorage)
+	})
+}
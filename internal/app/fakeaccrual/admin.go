@@ -0,0 +1,202 @@
+package fakeaccrual
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// orderScript is the admin representation of an order
+type orderScript struct {
+	Steps    []Step `json:"steps"`
+	Requests int    `json:"requests"`
+}
+
+// settings is the admin representation of Options. Durations are Go duration strings
+// such as "250ms" or "1m".
+type settings struct {
+	RateLimit  int    `json:"rate_limit"`
+	RetryAfter string `json:"retry_after,omitempty"`
+	Latency    string `json:"latency,omitempty"`
+}
+
+type throttle struct {
+	Requests   int    `json:"requests"`
+	RetryAfter string `json:"retry_after"`
+}
+
+// adminRoutes mounts the admin API:
+//
+//	GET    /admin/orders/{number}  scripted steps and the number of requests
+//	PUT    /admin/orders/{number}  {"steps": [{"status": "PROCESSED", "accrual": 500}]}
+//	DELETE /admin/orders/{number}  make the order unregistered
+//	GET    /admin/settings         rate limit, Retry-After and latency
+//	PUT    /admin/settings         {"rate_limit": 10, "retry_after": "60s", "latency": "100ms"}
+//	POST   /admin/throttle         {"requests": 3, "retry_after": "5s"}
+//	POST   /admin/reset            forget orders and restore the startup settings
+func (s *Server) adminRoutes(r chi.Router) {
+	r.Get("/orders/{number}", s.getOrder)
+	r.Put("/orders/{number}", s.putOrder)
+	r.Delete("/orders/{number}", s.deleteOrder)
+	r.Get("/settings", s.getSettings)
+	r.Put("/settings", s.putSettings)
+	r.Post("/throttle", s.postThrottle)
+	r.Post("/reset", s.postReset)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	s.mu.Lock()
+	sc, ok := s.orders[number]
+	var res orderScript
+	if ok {
+		res = orderScript{Steps: sc.steps, Requests: sc.requests}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "order is not registered", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *Server) putOrder(w http.ResponseWriter, r *http.Request) {
+	var req orderScript
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Steps) == 0 {
+		http.Error(w, "steps must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	for _, step := range req.Steps {
+		if err := step.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.SetOrder(chi.URLParam(r, "number"), req.Steps...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteOrder(w http.ResponseWriter, r *http.Request) {
+	s.RemoveOrder(chi.URLParam(r, "number"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getSettings(w http.ResponseWriter, r *http.Request) {
+	opts := s.Options()
+
+	writeJSON(w, settings{
+		RateLimit:  opts.RateLimit,
+		RetryAfter: opts.RetryAfter.String(),
+		Latency:    opts.Latency.String(),
+	})
+}
+
+func (s *Server) putSettings(w http.ResponseWriter, r *http.Request) {
+	var req settings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	retryAfter, err := parseDuration("retry_after", req.RetryAfter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	latency, err := parseDuration("latency", req.Latency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.RateLimit < 0 {
+		http.Error(w, "rate_limit must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	s.SetOptions(Options{RateLimit: req.RateLimit, RetryAfter: retryAfter, Latency: latency})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) postThrottle(w http.ResponseWriter, r *http.Request) {
+	var req throttle
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	retryAfter, err := parseDuration("retry_after", req.RetryAfter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Requests < 0 {
+		http.Error(w, "requests must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	s.Throttle(req.Requests, retryAfter)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) postReset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (step Step) validate() error {
+	if step.Code != 0 {
+		if step.Code < 100 || step.Code > 599 {
+			return fmt.Errorf("code %d is not an HTTP status", step.Code)
+		}
+		return nil
+	}
+
+	switch step.Status {
+	case StatusRegistered, StatusProcessing, StatusInvalid, StatusProcessed:
+	default:
+		return fmt.Errorf("unknown status %q", step.Status)
+	}
+
+	if step.Accrual < 0 {
+		return fmt.Errorf("accrual must not be negative")
+	}
+
+	return nil
+}
+
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration such as 60s", name)
+	}
+	return d, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(response)
+}
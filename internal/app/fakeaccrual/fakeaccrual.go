@@ -0,0 +1,283 @@
+// Package fakeaccrual is a stand-in for the accrual system for tests and local runs. It
+// serves GET /api/orders/{number} as described in SPECIFICATION.md, with responses
+// scripted per order, and can be told to rate limit, throttle or slow down. The same
+// controls are exposed as a JSON admin API under /admin.
+package fakeaccrual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// Accrual statuses as sent by the accrual system
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+// Step is one response to a request for an order. Code, when set, is sent instead of
+// the accrual, e.g. 204 while the order isn't registered yet or 500.
+type Step struct {
+	Status  string        `json:"status,omitempty"`
+	Accrual models.Amount `json:"accrual,omitempty"`
+	Code    int           `json:"code,omitempty"`
+}
+
+// Options configures Server
+type Options struct {
+	// RateLimit is the number of order requests allowed per minute, 0 means no limit
+	RateLimit int
+	// RetryAfter is sent with 429 responses. When 0, it is the time left until the
+	// rate limit window ends.
+	RetryAfter time.Duration
+	// Latency delays every order response
+	Latency time.Duration
+}
+
+// script is the scripted responses of an order. Each request moves to the next step
+// and the last step is repeated.
+type script struct {
+	steps    []Step
+	next     int
+	requests int
+}
+
+type Server struct {
+	mu sync.Mutex
+	// defaults are the options passed to New, restored by Reset
+	defaults Options
+	opts     Options
+	orders   map[string]*script
+	// throttled is the number of next order requests answered with 429
+	throttled        int
+	throttleRetry    time.Duration
+	windowStart      time.Time
+	requestsInWindow int
+
+	router chi.Router
+	now    func() time.Time
+}
+
+func New(opts Options) *Server {
+	s := &Server{
+		defaults: opts,
+		opts:     opts,
+		orders:   make(map[string]*script),
+		now:      time.Now,
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.order)
+	r.Route("/admin", s.adminRoutes)
+	s.router = r
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// SetOrder registers the order with the responses to send in turn. The last step is
+// repeated once reached.
+func (s *Server) SetOrder(number string, steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(steps) == 0 {
+		delete(s.orders, number)
+		return
+	}
+	s.orders[number] = &script{steps: steps}
+}
+
+// RemoveOrder makes the order unregistered, so that requests for it get 204
+func (s *Server) RemoveOrder(number string) {
+	s.SetOrder(number)
+}
+
+// Requests returns how many times the order was requested, including rejected requests
+func (s *Server) Requests(number string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sc, ok := s.orders[number]; ok {
+		return sc.requests
+	}
+	return 0
+}
+
+// Throttle answers the next n order requests with 429 and Retry-After of retryAfter
+func (s *Server) Throttle(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.throttled = n
+	s.throttleRetry = retryAfter
+}
+
+// SetOptions replaces the rate limit and latency settings
+func (s *Server) SetOptions(opts Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opts = opts
+	s.windowStart = time.Time{}
+	s.requestsInWindow = 0
+}
+
+// Options returns the current settings
+func (s *Server) Options() Options {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.opts
+}
+
+// Reset forgets all orders and restores the options passed to New
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opts = s.defaults
+	s.orders = make(map[string]*script)
+	s.throttled = 0
+	s.windowStart = time.Time{}
+	s.requestsInWindow = 0
+}
+
+// decision is how an order request is answered
+type decision struct {
+	latency    time.Duration
+	retryAfter time.Duration
+	limit      int
+	limited    bool
+	step       Step
+	found      bool
+}
+
+func (s *Server) decide(number string) decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := decision{latency: s.opts.Latency, limit: s.opts.RateLimit}
+
+	sc, found := s.orders[number]
+	if found {
+		sc.requests++
+	}
+
+	if s.throttled > 0 {
+		s.throttled--
+		d.limited = true
+		d.retryAfter = s.throttleRetry
+		return d
+	}
+
+	if s.opts.RateLimit > 0 {
+		now := s.now()
+		if now.Sub(s.windowStart) >= time.Minute {
+			s.windowStart = now
+			s.requestsInWindow = 0
+		}
+
+		s.requestsInWindow++
+		if s.requestsInWindow > s.opts.RateLimit {
+			d.limited = true
+			d.retryAfter = s.opts.RetryAfter
+			if d.retryAfter == 0 {
+				d.retryAfter = s.windowStart.Add(time.Minute).Sub(now)
+			}
+			return d
+		}
+	}
+
+	if !found {
+		return d
+	}
+
+	d.found = true
+	d.step = sc.steps[sc.next]
+	if sc.next < len(sc.steps)-1 {
+		sc.next++
+	}
+
+	return d
+}
+
+func (s *Server) order(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	d := s.decide(number)
+
+	if err := sleep(r.Context(), d.latency); err != nil {
+		return
+	}
+
+	if d.limited {
+		// Retry-After is in whole seconds, rounding up keeps clients from retrying too early
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(int((d.retryAfter+time.Second-1)/time.Second)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		if d.limit > 0 {
+			fmt.Fprintf(w, "No more than %d requests per minute allowed", d.limit)
+		} else {
+			fmt.Fprint(w, "Too many requests")
+		}
+		return
+	}
+
+	if !d.found {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if d.step.Code != 0 {
+		w.WriteHeader(d.step.Code)
+		return
+	}
+
+	response, err := json.Marshal(accrualResponse{Order: number, Status: d.step.Status, Accrual: d.step.Accrual})
+	if err != nil {
+		slog.Error("Fake accrual Marshal error", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(response)
+}
+
+// accrualResponse omits accrual when there is none, like the accrual system
+type accrualResponse struct {
+	Order   string        `json:"order"`
+	Status  string        `json:"status"`
+	Accrual models.Amount `json:"accrual,omitempty"`
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
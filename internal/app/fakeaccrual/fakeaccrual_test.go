@@ -0,0 +1,169 @@
+package fakeaccrual
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ScriptedOrder(t *testing.T) {
+	fake := New(Options{})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	fake.SetOrder("12345678903",
+		Step{Status: StatusRegistered},
+		Step{Code: http.StatusInternalServerError},
+		Step{Status: StatusProcessed, Accrual: models.NewAmount(500)},
+	)
+
+	cli := client.New(srv.URL + "/api/orders/")
+	ctx := context.Background()
+
+	order, err := cli.Request(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProcessing, order.Status)
+
+	_, err = cli.Request(ctx, "12345678903")
+	assert.ErrorIs(t, err, client.ErrServerError)
+
+	// The last step is repeated
+	for i := 0; i < 2; i++ {
+		order, err = cli.Request(ctx, "12345678903")
+		require.NoError(t, err)
+		assert.Equal(t, models.Order{Number: "12345678903", Status: models.StatusProcessed, Accrual: models.NewAmount(500)}, *order)
+	}
+	assert.Equal(t, 4, fake.Requests("12345678903"))
+
+	_, err = cli.Request(ctx, "79927398713")
+	assert.ErrorIs(t, err, client.ErrOrderNotRegistered)
+
+	fake.RemoveOrder("12345678903")
+	_, err = cli.Request(ctx, "12345678903")
+	assert.ErrorIs(t, err, client.ErrOrderNotRegistered)
+}
+
+func TestServer_AccrualOmittedWhenZero(t *testing.T) {
+	fake := New(Options{})
+	fake.SetOrder("12345678903", Step{Status: StatusInvalid})
+
+	w := httptest.NewRecorder()
+	fake.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/12345678903", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"order": "12345678903", "status": "INVALID"}`, w.Body.String())
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := New(Options{RateLimit: 2})
+	fake.now = func() time.Time { return now }
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		fake.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/12345678903", nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, get().Code)
+	now = now.Add(15 * time.Second)
+	assert.Equal(t, http.StatusNoContent, get().Code)
+
+	w := get()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "45", w.Header().Get("Retry-After"))
+	assert.Equal(t, "No more than 2 requests per minute allowed", w.Body.String())
+
+	// A new window starts a minute after the first request
+	now = now.Add(45 * time.Second)
+	assert.Equal(t, http.StatusNoContent, get().Code)
+}
+
+func TestServer_ThrottleAndLatency(t *testing.T) {
+	fake := New(Options{})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	fake.Throttle(1, 3*time.Second)
+
+	cli := client.New(srv.URL + "/api/orders/")
+	_, err := cli.Request(context.Background(), "12345678903")
+	assert.Equal(t, client.RetryAfterErr{T: 3}, err)
+
+	fake.SetOptions(Options{Latency: 50 * time.Millisecond})
+
+	start := time.Now()
+	res, err := http.Get(srv.URL + "/api/orders/12345678903")
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestServer_Admin(t *testing.T) {
+	fake := New(Options{RateLimit: 100})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(b)
+	}
+
+	code, _ := do(http.MethodPut, "/admin/orders/12345678903", `{"steps": [{"status": "PROCESSING"}, {"status": "PROCESSED", "accrual": 729.98}]}`)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	code, body := do(http.MethodGet, "/api/orders/12345678903", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"order": "12345678903", "status": "PROCESSING"}`, body)
+
+	code, body = do(http.MethodGet, "/admin/orders/12345678903", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"steps": [{"status": "PROCESSING"}, {"status": "PROCESSED", "accrual": 729.98}], "requests": 1}`, body)
+
+	code, _ = do(http.MethodPut, "/admin/settings", `{"rate_limit": 5, "retry_after": "60s", "latency": "10ms"}`)
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Equal(t, Options{RateLimit: 5, RetryAfter: time.Minute, Latency: 10 * time.Millisecond}, fake.Options())
+
+	code, _ = do(http.MethodPost, "/admin/throttle", `{"requests": 1, "retry_after": "2s"}`)
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do(http.MethodGet, "/api/orders/12345678903", "")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	code, _ = do(http.MethodPost, "/admin/reset", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Equal(t, Options{RateLimit: 100}, fake.Options())
+
+	code, _ = do(http.MethodGet, "/admin/orders/12345678903", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	for _, tc := range []struct{ method, path, body string }{
+		{http.MethodPut, "/admin/orders/1", `{"steps": []}`},
+		{http.MethodPut, "/admin/orders/1", `{"steps": [{"status": "DONE"}]}`},
+		{http.MethodPut, "/admin/orders/1", `{"steps": [{"code": 42}]}`},
+		{http.MethodPut, "/admin/settings", `{"latency": "soon"}`},
+		{http.MethodPut, "/admin/settings", `{"rate_limit": -1}`},
+		{http.MethodPost, "/admin/throttle", `{"requests": 1, "retry_after": "-1s"}`},
+	} {
+		code, _ := do(tc.method, tc.path, tc.body)
+		assert.Equal(t, http.StatusBadRequest, code, tc.body)
+	}
+}
@@ -0,0 +1,175 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type Client interface {
+	Request(context.Context, string) (*models.Order, error)
+}
+
+// Options configures Breaker
+type Options struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before trial requests are allowed
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests allowed while half-open
+	HalfOpenRequests int
+}
+
+// Breaker is a circuit breaker around the accrual client. Once FailureThreshold
+// requests in a row fail, requests are rejected with ErrOpen for OpenTimeout.
+// After that a few trial requests decide whether the circuit closes again.
+type Breaker struct {
+	client Client
+	opts   Options
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+func New(client Client, opts Options) *Breaker {
+	if opts.FailureThreshold < 1 {
+		opts.FailureThreshold = 1
+	}
+	if opts.HalfOpenRequests < 1 {
+		opts.HalfOpenRequests = 1
+	}
+
+	return &Breaker{
+		client: client,
+		opts:   opts,
+	}
+}
+
+func (b *Breaker) Request(ctx context.Context, number string) (*models.Order, error) {
+	if !b.allow() {
+		return nil, ErrOpen
+	}
+
+	res, err := b.client.Request(ctx, number)
+	b.record(err)
+
+	return res, err
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh()
+	return b.state
+}
+
+// Available reports whether requests may currently pass the breaker
+func (b *Breaker) Available() bool {
+	return b.State() != StateOpen
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh()
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.trials >= b.opts.HalfOpenRequests {
+			return false
+		}
+		b.trials++
+	}
+	return true
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isFailure(err) {
+		// Requests cancelled or timed out by the caller say nothing about the accrual system,
+		// the trial slot is just returned. Timeouts of the HTTP call are client.ErrTimeout.
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			if b.state == StateHalfOpen && b.trials > 0 {
+				b.trials--
+			}
+			return
+		}
+
+		b.failures = 0
+		if b.state == StateHalfOpen {
+			b.setState(StateClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.opts.FailureThreshold {
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+	}
+}
+
+// refresh moves an open circuit to half-open once OpenTimeout has passed
+func (b *Breaker) refresh() {
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.opts.OpenTimeout {
+		b.setState(StateHalfOpen)
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+
+	slog.Info("accrual circuit breaker state changed", slog.String("from", b.state.String()), slog.String("to", state.String()))
+	b.state = state
+	b.trials = 0
+	if state == StateClosed {
+		b.failures = 0
+	}
+}
+
+// isFailure reports whether err means the accrual system is unhealthy
+func isFailure(err error) bool {
+	return errors.Is(err, client.ErrConnectionRefused) ||
+		errors.Is(err, client.ErrTimeout) ||
+		errors.Is(err, client.ErrServerError) ||
+		errors.Is(err, client.ErrRequestFailed)
+}
@@ -0,0 +1,115 @@
+package breaker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/breaker"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestBreaker_OpensAfterThresholdAndRecovers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockClient(ctrl)
+	b := breaker.New(mockClient, breaker.Options{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond, HalfOpenRequests: 1})
+	ctx := context.Background()
+
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrConnectionRefused).Times(2)
+
+	_, err := b.Request(ctx, "1")
+	assert.ErrorIs(t, err, client.ErrConnectionRefused)
+	assert.Equal(t, breaker.StateClosed, b.State())
+
+	_, err = b.Request(ctx, "1")
+	assert.ErrorIs(t, err, client.ErrConnectionRefused)
+	assert.Equal(t, breaker.StateOpen, b.State())
+	assert.False(t, b.Available())
+
+	// Requests are rejected without reaching the client while open
+	_, err = b.Request(ctx, "1")
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, breaker.StateHalfOpen, b.State())
+	assert.True(t, b.Available())
+
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(&models.Order{Number: "1"}, nil)
+	_, err = b.Request(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, breaker.StateClosed, b.State())
+}
+
+func TestBreaker_HalfOpenFailureReopens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockClient(ctrl)
+	b := breaker.New(mockClient, breaker.Options{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond, HalfOpenRequests: 1})
+	ctx := context.Background()
+
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrServerError).Times(2)
+
+	_, _ = b.Request(ctx, "1")
+	assert.Equal(t, breaker.StateOpen, b.State())
+
+	time.Sleep(30 * time.Millisecond)
+	_, err := b.Request(ctx, "1")
+	assert.ErrorIs(t, err, client.ErrServerError)
+	assert.Equal(t, breaker.StateOpen, b.State())
+}
+
+func TestBreaker_IgnoresNonFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockClient(ctrl)
+	b := breaker.New(mockClient, breaker.Options{FailureThreshold: 1, OpenTimeout: time.Minute})
+	ctx := context.Background()
+
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrOrderNotRegistered)
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.RetryAfterErr{T: 60})
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, context.Canceled)
+
+	for i := 0; i < 3; i++ {
+		_, _ = b.Request(ctx, "1")
+	}
+	assert.Equal(t, breaker.StateClosed, b.State())
+}
+
+func TestBreaker_CallerDeadlineIsNotATrial(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := mocks.NewMockClient(ctrl)
+	b := breaker.New(mockClient, breaker.Options{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond, HalfOpenRequests: 1})
+	ctx := context.Background()
+
+	// A deadline of the caller doesn't reset the failure count
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrServerError)
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, context.DeadlineExceeded)
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrServerError)
+
+	for i := 0; i < 3; i++ {
+		_, _ = b.Request(ctx, "1")
+	}
+	assert.Equal(t, breaker.StateOpen, b.State())
+
+	// Nor does it close a half-open circuit, the trial slot is returned instead
+	time.Sleep(30 * time.Millisecond)
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, context.DeadlineExceeded)
+	_, err := b.Request(ctx, "1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, breaker.StateHalfOpen, b.State())
+
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrServerError)
+	_, err = b.Request(ctx, "1")
+	assert.ErrorIs(t, err, client.ErrServerError)
+	assert.Equal(t, breaker.StateOpen, b.State())
+}
@@ -3,15 +3,33 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log/slog"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
 )
 
+// defaultRetryAfter is used when a 429 response has no valid Retry-After header
+const defaultRetryAfter = 60
+
+// requestTimeout bounds a single HTTP call, time spent waiting on the limiter is not included
+const requestTimeout = 3 * time.Second
+
+var ErrConnectionRefused = errors.New("accrual system refused connection")
+var ErrTimeout = errors.New("accrual request timed out")
+var ErrRequestFailed = errors.New("accrual request failed")
+var ErrOrderNotRegistered = errors.New("order is not registered in accrual system")
+var ErrServerError = errors.New("accrual system internal error")
+var ErrUnexpectedStatus = errors.New("unexpected accrual response status")
+var ErrBadResponse = errors.New("accrual response can not be decoded")
+
 type RetryAfterErr struct {
 	T int
 }
@@ -23,50 +41,98 @@ func (e RetryAfterErr) Error() string {
 type AccrualClient struct {
 	cli     *http.Client
 	baseURL string
+	limiter *RateLimiter
 }
 
 func New(baseURL string) *AccrualClient {
 	return &AccrualClient{
-		cli:     &http.Client{},
+		cli:     &http.Client{Timeout: requestTimeout},
 		baseURL: baseURL,
+		limiter: NewRateLimiter(),
 	}
 }
 
 func (c *AccrualClient) Request(ctx context.Context, url string) (*models.Order, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+url, nil)
 	if err != nil {
-		slog.Error(err.Error())
-		panic(err)
+		return nil, fmt.Errorf("%w: %v", ErrRequestFailed, err)
 	}
 
 	request.Header.Add("Content-Type", "application/json")
 
 	response, err := c.cli.Do(request)
 	if err != nil {
-		panic(err)
+		return nil, classifyTransportError(err)
 	}
 
 	defer response.Body.Close()
 
-	var accrual dto.AccrualResponse
-	dec := json.NewDecoder(response.Body)
-	dec.DisallowUnknownFields()
+	switch {
+	case response.StatusCode == http.StatusOK:
+	case response.StatusCode == http.StatusNoContent:
+		return nil, ErrOrderNotRegistered
+	case response.StatusCode == http.StatusTooManyRequests:
+		retryTime, err := strconv.Atoi(response.Header.Get("Retry-After"))
+		if err != nil || retryTime < 0 {
+			retryTime = defaultRetryAfter
+		}
 
-	if response.StatusCode == http.StatusTooManyRequests {
-		retry := response.Header.Get("Retry-After")
-		retryTime, err := strconv.Atoi(retry)
-		if err != nil {
-			return nil, err
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		if limit, ok := parseLimit(body); ok {
+			c.limiter.SetLimit(limit)
 		}
+		c.limiter.Pause(time.Duration(retryTime) * time.Second)
 
 		return nil, RetryAfterErr{T: retryTime}
+	case response.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: status %d", ErrServerError, response.StatusCode)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnexpectedStatus, response.StatusCode)
 	}
 
+	var accrual dto.AccrualResponse
+	dec := json.NewDecoder(response.Body)
+	dec.DisallowUnknownFields()
+
 	err = dec.Decode(&accrual)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrBadResponse, err)
+	}
+
+	order := ToOrder(accrual)
+	return &order, nil
+}
+
+// ToOrder converts an accrual system response to an order update.
+// REGISTERED means the accrual is not calculated yet, so the order is PROCESSING for the user.
+func ToOrder(accrual dto.AccrualResponse) models.Order {
+	status := models.OrderStatus(accrual.Status)
+	if accrual.Status == "REGISTERED" {
+		status = models.StatusProcessing
+	}
+
+	return models.Order{Accrual: accrual.Accrual, Status: status, Number: accrual.Order}
+}
+
+// classifyTransportError maps an error returned by http.Client.Do to one of the client errors.
+// Context cancellation is returned as is so callers can tell shutdown from failures.
+func classifyTransportError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%w: %v", ErrConnectionRefused, err)
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
 	}
 
-	return &models.Order{Accrual: accrual.Accrual, Status: models.OrderStatus(accrual.Status), Number: accrual.Order}, nil
+	return fmt.Errorf("%w: %v", ErrRequestFailed, err)
 }
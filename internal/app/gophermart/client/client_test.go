@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest_Success(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/orders/12345678903", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`))
+	}))
+	defer srv.Close()
+
+	order, err := New(srv.URL+"/api/orders/").Request(context.Background(), "12345678903")
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678903", order.Number)
+	assert.Equal(t, models.StatusProcessed, order.Status)
+	assert.Equal(t, models.NewAmount(500), order.Accrual)
+}
+
+func TestToOrder_MapsRegisteredToProcessing(t *testing.T) {
+	order := ToOrder(dto.AccrualResponse{Order: "1", Status: "REGISTERED"})
+	assert.Equal(t, models.StatusProcessing, order.Status)
+}
+
+func TestRequest_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    error
+	}{
+		{
+			name:    "not registered",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+			want:    ErrOrderNotRegistered,
+		},
+		{
+			name:    "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			want:    ErrServerError,
+		},
+		{
+			name:    "unexpected status",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			want:    ErrUnexpectedStatus,
+		},
+		{
+			name:    "bad body",
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("not json")) },
+			want:    ErrBadResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			order, err := New(srv.URL+"/api/orders/").Request(context.Background(), "12345678903")
+			assert.Nil(t, order)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestRequest_ConnectionRefused(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	_, err := New(url+"/api/orders/").Request(context.Background(), "12345678903")
+	assert.ErrorIs(t, err, ErrConnectionRefused)
+}
+
+func TestRequest_Timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL + "/api/orders/")
+	c.cli.Timeout = 20 * time.Millisecond
+
+	_, err := c.Request(context.Background(), "12345678903")
+	assert.ErrorIs(t, err, ErrTimeout)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitBody matches the body the accrual system sends with 429 responses
+var rateLimitBody = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
+// RateLimiter throttles every request sent to the accrual system. A single
+// 429 response pauses all callers until the Retry-After window has passed.
+type RateLimiter struct {
+	mu          sync.Mutex
+	pausedUntil time.Time
+	next        time.Time
+	interval    time.Duration
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{}
+}
+
+// Wait blocks until a request is allowed or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		at := now
+		if l.pausedUntil.After(at) {
+			at = l.pausedUntil
+		}
+		if l.next.After(at) {
+			at = l.next
+		}
+		if !at.After(now) {
+			l.next = now.Add(l.interval)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(at.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pause stops all requests for d
+func (l *RateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		slog.Info("accrual requests paused", slog.Duration("for", d))
+		l.pausedUntil = until
+	}
+}
+
+// SetLimit spaces requests evenly so that no more than perMinute are sent per minute
+func (l *RateLimiter) SetLimit(perMinute int) {
+	if perMinute <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	interval := time.Minute / time.Duration(perMinute)
+	if interval != l.interval {
+		slog.Info("accrual rate limit changed", slog.Int("requests_per_minute", perMinute))
+		l.interval = interval
+	}
+}
+
+// parseLimit extracts N from a "No more than N requests per minute allowed" body
+func parseLimit(body []byte) (int, bool) {
+	m := rateLimitBody.FindSubmatch(body)
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_PauseBlocksUntilWindowPasses(t *testing.T) {
+	l := NewRateLimiter()
+	l.Pause(100 * time.Millisecond)
+
+	start := time.Now()
+	err := l.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestRateLimiter_WaitRespectsContext(t *testing.T) {
+	l := NewRateLimiter()
+	l.Pause(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := l.Wait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRateLimiter_SetLimitSpacesRequests(t *testing.T) {
+	l := NewRateLimiter()
+	l.SetLimit(1200) // one request every 50ms
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, l.Wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestParseLimit(t *testing.T) {
+	n, ok := parseLimit([]byte("No more than 10 requests per minute allowed"))
+	assert.True(t, ok)
+	assert.Equal(t, 10, n)
+
+	_, ok = parseLimit([]byte("Too Many Requests"))
+	assert.False(t, ok)
+}
+
+func TestRequest_TooManyRequestsPausesAllCalls(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("No more than 30 requests per minute allowed"))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL + "/api/orders/")
+
+	_, err := c.Request(context.Background(), "12345678903")
+	var rae RetryAfterErr
+	assert.ErrorAs(t, err, &rae)
+	assert.Equal(t, 60, rae.T)
+	assert.Equal(t, 2*time.Second, c.limiter.interval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = c.Request(ctx, "12345678903")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,339 @@
+package contract_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/fakeaccrual"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scenario is a row of the response code tables in SPECIFICATION.md. prepare sets up
+// the state the scenario needs and returns the request to send.
+type scenario struct {
+	name    string
+	prepare func(t *testing.T, h *harness) request
+	want    int
+}
+
+func runScenarios(t *testing.T, h *harness, scenarios []scenario) {
+	t.Helper()
+
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			res := h.do(t, sc.prepare(t, h))
+			assert.Equal(t, sc.want, res.status, res.body)
+		})
+	}
+}
+
+// authorized returns a request of a newly registered user
+func authorized(method, path, body string) func(t *testing.T, h *harness) request {
+	return func(t *testing.T, h *harness) request {
+		_, token := h.register(t)
+		return request{method: method, path: path, token: token, body: body}
+	}
+}
+
+// anonymous returns a request without a token
+func anonymous(method, path, body string) func(t *testing.T, h *harness) request {
+	return func(t *testing.T, h *harness) request {
+		return request{method: method, path: path, body: body}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	h := newHarness(t)
+
+	runScenarios(t, h, []scenario{
+		{
+			name: "200 registered and authenticated",
+			prepare: func(t *testing.T, h *harness) request {
+				return request{method: http.MethodPost, path: "/api/user/register", body: credentials(newLogin(), "password")}
+			},
+			want: http.StatusOK,
+		},
+		{
+			name:    "400 malformed body",
+			prepare: anonymous(http.MethodPost, "/api/user/register", `{"login": "user"`),
+			want:    http.StatusBadRequest,
+		},
+		{
+			name: "409 login taken",
+			prepare: func(t *testing.T, h *harness) request {
+				login, _ := h.register(t)
+				return request{method: http.MethodPost, path: "/api/user/register", body: credentials(login, "another")}
+			},
+			want: http.StatusConflict,
+		},
+	})
+}
+
+func TestLogin(t *testing.T) {
+	h := newHarness(t)
+
+	runScenarios(t, h, []scenario{
+		{
+			name: "200 authenticated",
+			prepare: func(t *testing.T, h *harness) request {
+				login, _ := h.register(t)
+				return request{method: http.MethodPost, path: "/api/user/login", body: credentials(login, "password")}
+			},
+			want: http.StatusOK,
+		},
+		{
+			name:    "400 malformed body",
+			prepare: anonymous(http.MethodPost, "/api/user/login", "{"),
+			want:    http.StatusBadRequest,
+		},
+		{
+			name: "401 wrong password",
+			prepare: func(t *testing.T, h *harness) request {
+				login, _ := h.register(t)
+				return request{method: http.MethodPost, path: "/api/user/login", body: credentials(login, "wrong")}
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "401 unknown login",
+			prepare: func(t *testing.T, h *harness) request {
+				return request{method: http.MethodPost, path: "/api/user/login", body: credentials(newLogin(), "password")}
+			},
+			want: http.StatusUnauthorized,
+		},
+	})
+}
+
+func TestUploadOrder(t *testing.T) {
+	h := newHarness(t)
+
+	runScenarios(t, h, []scenario{
+		{
+			name: "202 accepted",
+			prepare: func(t *testing.T, h *harness) request {
+				return authorized(http.MethodPost, "/api/user/orders", newOrderNumber())(t, h)
+			},
+			want: http.StatusAccepted,
+		},
+		{
+			name: "200 already uploaded by the user",
+			prepare: func(t *testing.T, h *harness) request {
+				req := authorized(http.MethodPost, "/api/user/orders", newOrderNumber())(t, h)
+				res := h.do(t, req)
+				require.Equal(t, http.StatusAccepted, res.status, res.body)
+				return req
+			},
+			want: http.StatusOK,
+		},
+		{
+			name:    "400 not a number",
+			prepare: authorized(http.MethodPost, "/api/user/orders", "12345abc"),
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "401 unauthenticated",
+			prepare: anonymous(http.MethodPost, "/api/user/orders", "12345678903"),
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name: "409 uploaded by another user",
+			prepare: func(t *testing.T, h *harness) request {
+				number := newOrderNumber()
+				res := h.do(t, authorized(http.MethodPost, "/api/user/orders", number)(t, h))
+				require.Equal(t, http.StatusAccepted, res.status, res.body)
+				return authorized(http.MethodPost, "/api/user/orders", number)(t, h)
+			},
+			want: http.StatusConflict,
+		},
+		{
+			name: "422 fails the Luhn check",
+			prepare: func(t *testing.T, h *harness) request {
+				return authorized(http.MethodPost, "/api/user/orders", invalidOrderNumber())(t, h)
+			},
+			want: http.StatusUnprocessableEntity,
+		},
+	})
+}
+
+func TestListOrders(t *testing.T) {
+	h := newHarness(t)
+
+	runScenarios(t, h, []scenario{
+		{
+			name:    "204 no orders",
+			prepare: authorized(http.MethodGet, "/api/user/orders", ""),
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "401 unauthenticated",
+			prepare: anonymous(http.MethodGet, "/api/user/orders", ""),
+			want:    http.StatusUnauthorized,
+		},
+	})
+
+	t.Run("200 newest first with accruals of processed orders", func(t *testing.T) {
+		_, token := h.register(t)
+
+		processed, invalid, registered := newOrderNumber(), newOrderNumber(), newOrderNumber()
+		h.accrual.SetOrder(processed, fakeaccrual.Step{Status: fakeaccrual.StatusRegistered}, fakeaccrual.Step{Status: fakeaccrual.StatusProcessed, Accrual: 50050})
+		h.accrual.SetOrder(invalid, fakeaccrual.Step{Status: fakeaccrual.StatusInvalid})
+		h.accrual.SetOrder(registered, fakeaccrual.Step{Status: fakeaccrual.StatusRegistered})
+
+		for _, number := range []string{processed, invalid, registered} {
+			res := h.do(t, request{method: http.MethodPost, path: "/api/user/orders", token: token, body: number})
+			require.Equal(t, http.StatusAccepted, res.status, res.body)
+		}
+
+		var orders []map[string]any
+		h.eventually(t, func() bool {
+			res := h.do(t, request{method: http.MethodGet, path: "/api/user/orders", token: token})
+			if res.status != http.StatusOK || json.Unmarshal([]byte(res.body), &orders) != nil || len(orders) != 3 {
+				return false
+			}
+			return orders[0]["status"] == "PROCESSING" && orders[1]["status"] == "INVALID" && orders[2]["status"] == "PROCESSED"
+		})
+
+		assert.Equal(t, []any{registered, invalid, processed}, []any{orders[0]["number"], orders[1]["number"], orders[2]["number"]})
+		assert.Equal(t, 500.5, orders[2]["accrual"])
+		assert.NotContains(t, orders[0], "accrual")
+		assert.NotContains(t, orders[1], "accrual")
+
+		for _, order := range orders {
+			for field := range order {
+				assert.Contains(t, []string{"number", "status", "accrual", "uploaded_at"}, field)
+			}
+			_, err := time.Parse(time.RFC3339, order["uploaded_at"].(string))
+			assert.NoError(t, err)
+		}
+	})
+}
+
+func TestBalance(t *testing.T) {
+	h := newHarness(t)
+
+	runScenarios(t, h, []scenario{
+		{
+			name:    "401 unauthenticated",
+			prepare: anonymous(http.MethodGet, "/api/user/balance", ""),
+			want:    http.StatusUnauthorized,
+		},
+	})
+
+	t.Run("200 current and withdrawn", func(t *testing.T) {
+		_, token := h.register(t)
+
+		res := h.do(t, request{method: http.MethodGet, path: "/api/user/balance", token: token})
+		require.Equal(t, http.StatusOK, res.status, res.body)
+		assert.Equal(t, "application/json", res.header.Get("Content-Type"))
+		assert.JSONEq(t, `{"current": 0, "withdrawn": 0}`, res.body)
+
+		h.fund(t, token, 500)
+		res = h.do(t, request{method: http.MethodPost, path: "/api/user/balance/withdraw", token: token, body: withdrawal(newOrderNumber(), "42")})
+		require.Equal(t, http.StatusOK, res.status, res.body)
+
+		res = h.do(t, request{method: http.MethodGet, path: "/api/user/balance", token: token})
+		require.Equal(t, http.StatusOK, res.status, res.body)
+		assert.JSONEq(t, `{"current": 458, "withdrawn": 42}`, res.body)
+	})
+}
+
+func TestWithdraw(t *testing.T) {
+	h := newHarness(t)
+
+	runScenarios(t, h, []scenario{
+		{
+			name: "200 withdrawn",
+			prepare: func(t *testing.T, h *harness) request {
+				_, token := h.register(t)
+				h.fund(t, token, 100)
+				return request{method: http.MethodPost, path: "/api/user/balance/withdraw", token: token, body: withdrawal(newOrderNumber(), "100")}
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "401 unauthenticated",
+			prepare: func(t *testing.T, h *harness) request {
+				return anonymous(http.MethodPost, "/api/user/balance/withdraw", withdrawal(newOrderNumber(), "1"))(t, h)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "402 insufficient funds",
+			prepare: func(t *testing.T, h *harness) request {
+				_, token := h.register(t)
+				h.fund(t, token, 100)
+				return request{method: http.MethodPost, path: "/api/user/balance/withdraw", token: token, body: withdrawal(newOrderNumber(), "100.01")}
+			},
+			want: http.StatusPaymentRequired,
+		},
+		{
+			name: "402 empty balance",
+			prepare: func(t *testing.T, h *harness) request {
+				return authorized(http.MethodPost, "/api/user/balance/withdraw", withdrawal(newOrderNumber(), "1"))(t, h)
+			},
+			want: http.StatusPaymentRequired,
+		},
+		{
+			name: "422 order fails the Luhn check",
+			prepare: func(t *testing.T, h *harness) request {
+				return authorized(http.MethodPost, "/api/user/balance/withdraw", withdrawal(invalidOrderNumber(), "1"))(t, h)
+			},
+			want: http.StatusUnprocessableEntity,
+		},
+	})
+}
+
+func TestWithdrawals(t *testing.T) {
+	h := newHarness(t)
+
+	runScenarios(t, h, []scenario{
+		{
+			name:    "204 no withdrawals",
+			prepare: authorized(http.MethodGet, "/api/user/withdrawals", ""),
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "401 unauthenticated",
+			prepare: anonymous(http.MethodGet, "/api/user/withdrawals", ""),
+			want:    http.StatusUnauthorized,
+		},
+	})
+
+	t.Run("200 newest first", func(t *testing.T) {
+		_, token := h.register(t)
+		h.fund(t, token, 100)
+
+		first, second := newOrderNumber(), newOrderNumber()
+		for _, w := range []struct{ order, sum string }{{first, "10"}, {second, "20.5"}} {
+			res := h.do(t, request{method: http.MethodPost, path: "/api/user/balance/withdraw", token: token, body: withdrawal(w.order, w.sum)})
+			require.Equal(t, http.StatusOK, res.status, res.body)
+		}
+
+		res := h.do(t, request{method: http.MethodGet, path: "/api/user/withdrawals", token: token})
+		require.Equal(t, http.StatusOK, res.status, res.body)
+		assert.Equal(t, "application/json", res.header.Get("Content-Type"))
+
+		var withdrawals []map[string]any
+		require.NoError(t, json.Unmarshal([]byte(res.body), &withdrawals))
+		require.Len(t, withdrawals, 2)
+
+		assert.Equal(t, second, withdrawals[0]["order"])
+		assert.Equal(t, 20.5, withdrawals[0]["sum"])
+		assert.Equal(t, first, withdrawals[1]["order"])
+		assert.Equal(t, 10.0, withdrawals[1]["sum"])
+
+		for _, w := range withdrawals {
+			assert.Len(t, w, 3)
+			_, err := time.Parse(time.RFC3339, w["processed_at"].(string))
+			assert.NoError(t, err)
+		}
+	})
+}
+
+func withdrawal(order, sum string) string {
+	return fmt.Sprintf(`{"order": %q, "sum": %s}`, order, sum)
+}
@@ -0,0 +1,215 @@
+package contract_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/fakeaccrual"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/breaker"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/loginlimit"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/server"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/service"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/worker"
+	"github.com/atinyakov/go-musthave-diploma/internal/db"
+	"github.com/atinyakov/go-musthave-diploma/pkg/auth"
+	"github.com/atinyakov/go-musthave-diploma/pkg/luhn"
+	"github.com/atinyakov/go-musthave-diploma/pkg/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+// harness is a running gophermart backed by the test database and a fake accrual system
+type harness struct {
+	url     string
+	accrual *fakeaccrual.Server
+}
+
+// newHarness assembles the server the way main does
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	repo := newRepository(t)
+
+	accrual := fakeaccrual.New(fakeaccrual.Options{})
+	accrualServer := httptest.NewServer(accrual)
+	t.Cleanup(accrualServer.Close)
+
+	key, err := auth.NewHMACKey("1", []byte("contract-test-secret"))
+	require.NoError(t, err)
+	tokens, err := auth.NewJWT(15*time.Minute, key)
+	require.NoError(t, err)
+
+	circuit := breaker.New(client.New(accrualServer.URL+"/api/orders/"), breaker.Options{FailureThreshold: 5, OpenTimeout: time.Second})
+	fetcher := worker.NewAccrualTaskWorker(repo, circuit, worker.Options{Workers: 2, UnregisteredTimeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fetcher.StartOrderFetcher(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	svc := service.New(repo)
+	sessions := session.New(repo, tokens, 15*time.Minute, time.Hour)
+	// Counters in memory keep the failed logins of one run from locking out the next
+	limiter := loginlimit.New(loginlimit.NewMemoryStore(), loginlimit.Options{})
+
+	r := server.New(
+		handler.NewPost(svc, sessions, limiter),
+		handler.NewGet(svc),
+		nil,
+		handler.NewHealth(circuit),
+		middleware.NewAuth(tokens, sessions),
+		handler.NewIdempotency(repo),
+	)
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return &harness{url: srv.URL, accrual: accrual}
+}
+
+// newRepository connects to the database from TEST_DATABASE_URI, or keeps the data in
+// memory when it is not set
+func newRepository(t *testing.T) repository.Backend {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		return repository.NewMemoryRepository()
+	}
+
+	conn := db.InitDB(dsn)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	migrator, err := db.NewMigrator(conn)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	return repository.New(conn)
+}
+
+// request is an API call. Body is sent as text/plain when it doesn't start with {.
+type request struct {
+	method string
+	path   string
+	token  string
+	body   string
+}
+
+// response is a completed API call
+type response struct {
+	status int
+	header http.Header
+	body   string
+}
+
+func (h *harness) do(t *testing.T, req request) response {
+	t.Helper()
+
+	httpReq, err := http.NewRequest(req.method, h.url+req.path, strings.NewReader(req.body))
+	require.NoError(t, err)
+
+	if req.body != "" {
+		contentType := "text/plain"
+		if strings.HasPrefix(req.body, "{") {
+			contentType = "application/json"
+		}
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if req.token != "" {
+		httpReq.Header.Set("Authorization", req.token)
+	}
+
+	res, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return response{status: res.StatusCode, header: res.Header, body: string(body)}
+}
+
+var loginSeq atomic.Int64
+
+// newLogin returns a login no previous run has registered
+func newLogin() string {
+	return fmt.Sprintf("contract-%d-%d", time.Now().UnixNano(), loginSeq.Add(1))
+}
+
+// register signs up a new user and returns the login and the Authorization header
+func (h *harness) register(t *testing.T) (string, string) {
+	t.Helper()
+
+	login := newLogin()
+	res := h.do(t, request{method: http.MethodPost, path: "/api/user/register", body: credentials(login, "password")})
+	require.Equal(t, http.StatusOK, res.status, res.body)
+	require.NotEmpty(t, res.header.Get("Authorization"))
+
+	return login, res.header.Get("Authorization")
+}
+
+func credentials(login, password string) string {
+	return fmt.Sprintf(`{"login": %q, "password": %q}`, login, password)
+}
+
+var orderSeq atomic.Int64
+
+// newOrderNumber returns a Luhn-valid order number no previous run has used
+func newOrderNumber() string {
+	n := int(time.Now().UnixNano()/1000 + orderSeq.Add(1))
+	return strconv.Itoa(n*10 + luhn.Calculate(n))
+}
+
+// fund credits amount points to the user by uploading an order the accrual system
+// processes, and waits until the balance shows them
+func (h *harness) fund(t *testing.T, token string, amount int) {
+	t.Helper()
+
+	number := newOrderNumber()
+	h.accrual.SetOrder(number, fakeaccrual.Step{Status: fakeaccrual.StatusProcessed, Accrual: models.NewAmount(int64(amount))})
+
+	res := h.do(t, request{method: http.MethodPost, path: "/api/user/orders", token: token, body: number})
+	require.Equal(t, http.StatusAccepted, res.status, res.body)
+
+	h.eventually(t, func() bool {
+		res := h.do(t, request{method: http.MethodGet, path: "/api/user/balance", token: token})
+		var balance dto.BalanceResponce
+		return json.Unmarshal([]byte(res.body), &balance) == nil && balance.Current == models.NewAmount(int64(amount))
+	})
+}
+
+// eventually waits for the accrual worker to make condition true
+func (h *harness) eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	require.Eventually(t, condition, 10*time.Second, 100*time.Millisecond)
+}
+
+// invalidOrderNumber returns an order number that fails the Luhn check
+func invalidOrderNumber() string {
+	valid := newOrderNumber()
+	last := valid[len(valid)-1] - '0'
+	return valid[:len(valid)-1] + strconv.Itoa(int(last+1)%10)
+}
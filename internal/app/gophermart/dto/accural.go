@@ -1,7 +1,9 @@
 package dto
 
+import "github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+
 type AccrualResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string        `json:"order"`
+	Status  string        `json:"status"`
+	Accrual models.Amount `json:"accrual"`
 }
@@ -1,6 +1,8 @@
 package dto
 
+import "github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+
 type BalanceResponce struct {
-	Current   float32 `json:"current" validate:"required"`
-	Withdrawn float32 `json:"withdrawn" validate:"required"`
+	Current   models.Amount `json:"current" validate:"required"`
+	Withdrawn models.Amount `json:"withdrawn" validate:"required"`
 }
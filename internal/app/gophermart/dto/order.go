@@ -0,0 +1,12 @@
+package dto
+
+import "github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+
+// OrderResponseItem is an order in the orders listing. Accrual is present only for
+// PROCESSED orders.
+type OrderResponseItem struct {
+	Number     string             `json:"number"`
+	Status     models.OrderStatus `json:"status"`
+	Accrual    *models.Amount     `json:"accrual,omitempty"`
+	UploadedAt string             `json:"uploaded_at" format:"RFC3339"`
+}
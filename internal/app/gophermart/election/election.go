@@ -0,0 +1,117 @@
+package election
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+)
+
+// Elector makes sure only one replica runs a task at a time using a PostgreSQL
+// session-level advisory lock. The lock is released by PostgreSQL when the
+// leader's session ends, so another replica takes over when the leader dies.
+type Elector struct {
+	db       *sql.DB
+	key      int64
+	interval time.Duration
+}
+
+func New(db *sql.DB, key int64, interval time.Duration) *Elector {
+	return &Elector{
+		db:       db,
+		key:      key,
+		interval: interval,
+	}
+}
+
+// Run tries to become the leader every interval and calls task while leading.
+// The context passed to task is cancelled when leadership is lost or ctx is done.
+// Run blocks until ctx is done and task has returned.
+func (e *Elector) Run(ctx context.Context, task func(context.Context)) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if conn, ok := e.acquire(ctx); ok {
+			e.lead(ctx, conn, ticker, task)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire returns a connection holding the lock when this replica becomes the leader
+func (e *Elector) acquire(ctx context.Context) (*sql.Conn, bool) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		slog.Error("leader election: failed to get connection", slog.String("error", err.Error()))
+		return nil, false
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&locked)
+	if err != nil || !locked {
+		if err != nil {
+			slog.Error("leader election: failed to take lock", slog.String("error", err.Error()))
+		}
+		_ = conn.Close()
+		return nil, false
+	}
+
+	slog.Info("leader election: became leader", slog.Int64("key", e.key))
+	return conn, true
+}
+
+// lead runs task until ctx is done or the lock connection is lost
+func (e *Elector) lead(ctx context.Context, conn *sql.Conn, ticker *time.Ticker, task func(context.Context)) {
+	taskCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		task(taskCtx)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+
+		e.release(context.WithoutCancel(ctx), conn)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.PingContext(ctx); err != nil {
+				slog.Error("leader election: lost leadership", slog.String("error", err.Error()))
+				return
+			}
+		}
+	}
+}
+
+// release unlocks the lock and closes conn. If unlocking fails the session may still hold
+// the lock, so the connection is discarded instead of being returned to the pool where the
+// next user would keep the leadership without knowing it.
+func (e *Elector) release(ctx context.Context, conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", e.key)
+	if err != nil {
+		slog.Error("leader election: failed to release lock, discarding connection", slog.String("error", err.Error()))
+		_ = conn.Raw(func(any) error {
+			return driver.ErrBadConn
+		})
+	}
+
+	_ = conn.Close()
+}
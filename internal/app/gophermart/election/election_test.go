@@ -0,0 +1,125 @@
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_RunsTaskOnlyWhileLeading(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Another replica holds the lock on the first attempt
+	mock.ExpectQuery("SELECT pg_try_advisory_lock\\(\\$1\\)").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
+	mock.ExpectQuery("SELECT pg_try_advisory_lock\\(\\$1\\)").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectExec("SELECT pg_advisory_unlock\\(\\$1\\)").
+		WithArgs(int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		New(db, 42, 10*time.Millisecond).Run(ctx, func(taskCtx context.Context) {
+			close(started)
+			<-taskCtx.Done()
+			close(stopped)
+		})
+		close(done)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("task was not started after the lock was taken")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("task was not stopped before Run returned")
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRun_StepsDownWhenConnectionIsLost(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock\\(\\$1\\)").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectPing().WillReturnError(assert.AnError)
+	mock.ExpectExec("SELECT pg_advisory_unlock\\(\\$1\\)").
+		WithArgs(int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go New(db, 42, 10*time.Millisecond).Run(ctx, func(taskCtx context.Context) {
+		<-taskCtx.Done()
+		close(stopped)
+	})
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("task was not stopped after the lock connection was lost")
+	}
+}
+
+func TestRelease_DiscardsConnectionWhenUnlockFails(t *testing.T) {
+	tests := []struct {
+		name      string
+		unlockErr error
+		wantOpen  int
+	}{
+		{name: "unlocked connection is returned to the pool", wantOpen: 1},
+		{name: "connection that may hold the lock is discarded", unlockErr: assert.AnError, wantOpen: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			unlock := mock.ExpectExec("SELECT pg_advisory_unlock\\(\\$1\\)").WithArgs(int64(42))
+			if tt.unlockErr != nil {
+				unlock.WillReturnError(tt.unlockErr)
+			} else {
+				unlock.WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			conn, err := db.Conn(context.Background())
+			assert.NoError(t, err)
+
+			New(db, 42, time.Second).release(context.Background(), conn)
+
+			assert.Equal(t, tt.wantOpen, db.Stats().OpenConnections)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
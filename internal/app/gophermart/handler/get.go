@@ -2,8 +2,13 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
@@ -13,9 +18,17 @@ import (
 type ServiceGet interface {
 	GetWithdrawals(string) ([]dto.WithdrawalResponseItem, error)
 	GetBalance(string) (dto.BalanceResponce, error)
-	GetOrdersByUsername(string) ([]models.Order, error)
+	GetOrders(models.OrderQuery) ([]dto.OrderResponseItem, *models.OrderCursor, error)
 }
 
+const (
+	// defaultOrdersLimit is the page size when a cursor is given without a limit
+	defaultOrdersLimit = 100
+	maxOrdersLimit     = 1000
+	// NextCursorHeader carries the cursor of the next page of a listing
+	NextCursorHeader = "X-Next-Cursor"
+)
+
 type GetHandler struct {
 	service ServiceGet
 }
@@ -26,10 +39,22 @@ func NewGet(service ServiceGet) *GetHandler {
 	}
 }
 
+// Orders lists the orders of the user, newest first. Without query parameters all orders
+// are returned. Otherwise they may be filtered by status (repeated or comma separated) and
+// by an uploaded_at range [from, to) in RFC 3339, sorted by sort=uploaded_at or
+// sort=-uploaded_at, and paged by limit and cursor. The cursor of the next page is sent in
+// the X-Next-Cursor header and as a Link header with rel="next".
 func (gh *GetHandler) Orders(w http.ResponseWriter, r *http.Request) {
 	username := r.Context().Value(middleware.UserContextKey).(string)
 
-	orders, err := gh.service.GetOrdersByUsername(username)
+	q, err := parseOrderQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Username = username
+
+	orders, next, err := gh.service.GetOrders(q)
 
 	if err != nil {
 		slog.Error("Get Orders DB error", slog.String("error", err.Error()))
@@ -42,6 +67,17 @@ func (gh *GetHandler) Orders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if next != nil {
+		cursor := next.Encode()
+		nextURL := *r.URL
+		query := nextURL.Query()
+		query.Set("cursor", cursor)
+		nextURL.RawQuery = query.Encode()
+
+		w.Header().Set(NextCursorHeader, cursor)
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.RequestURI()))
+	}
+
 	response, err := json.Marshal(orders)
 	if err != nil {
 		slog.Error("Get Orders Marshal error", slog.String("error", err.Error()))
@@ -115,3 +151,61 @@ func (gh *GetHandler) Withdrawals(w http.ResponseWriter, r *http.Request) {
 		slog.Error("writeErr error", slog.String("error", writeErr.Error()))
 	}
 }
+
+// parseOrderQuery reads the filtering, sorting and paging parameters of the orders listing
+func parseOrderQuery(r *http.Request) (models.OrderQuery, error) {
+	var q models.OrderQuery
+	params := r.URL.Query()
+
+	for _, value := range params["status"] {
+		for _, status := range strings.Split(value, ",") {
+			status := models.OrderStatus(strings.ToUpper(strings.TrimSpace(status)))
+			switch status {
+			case models.StatusNew, models.StatusProcessing, models.StatusInvalid, models.StatusProcessed:
+				q.Statuses = append(q.Statuses, status)
+			default:
+				return q, fmt.Errorf("unknown status %q", status)
+			}
+		}
+	}
+
+	for name, bound := range map[string]**time.Time{"from": &q.UploadedFrom, "to": &q.UploadedTo} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return q, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*bound = &t
+	}
+
+	switch params.Get("sort") {
+	case "", "-uploaded_at":
+	case "uploaded_at":
+		q.Ascending = true
+	default:
+		return q, errors.New("sort must be uploaded_at or -uploaded_at")
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := models.ParseOrderCursor(value)
+		if err != nil {
+			return q, err
+		}
+		q.After = &cursor
+		q.Limit = defaultOrdersLimit
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxOrdersLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxOrdersLimit)
+		}
+		q.Limit = limit
+	}
+
+	return q, nil
+}
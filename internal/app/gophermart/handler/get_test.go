@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
@@ -25,7 +26,7 @@ func TestGetOrders(t *testing.T) {
 	h := handler.NewGet(mockService)
 
 	t.Run("success", func(t *testing.T) {
-		mockService.EXPECT().GetOrdersByUsername("testuser").Return([]models.Order{{Number: "12345"}}, nil)
+		mockService.EXPECT().GetOrders(models.OrderQuery{Username: "testuser"}).Return([]dto.OrderResponseItem{{Number: "12345"}}, nil, nil)
 		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
 		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
 		w := httptest.NewRecorder()
@@ -33,9 +34,10 @@ func TestGetOrders(t *testing.T) {
 		h.Orders(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Link"))
 	})
 	t.Run("no content", func(t *testing.T) {
-		mockService.EXPECT().GetOrdersByUsername("testuser").Return([]models.Order{}, nil)
+		mockService.EXPECT().GetOrders(models.OrderQuery{Username: "testuser"}).Return([]dto.OrderResponseItem{}, nil, nil)
 		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
 		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
 		w := httptest.NewRecorder()
@@ -45,7 +47,7 @@ func TestGetOrders(t *testing.T) {
 		assert.Equal(t, http.StatusNoContent, w.Code)
 	})
 	t.Run("err", func(t *testing.T) {
-		mockService.EXPECT().GetOrdersByUsername("testuser").Return([]models.Order{}, errors.New("123"))
+		mockService.EXPECT().GetOrders(models.OrderQuery{Username: "testuser"}).Return(nil, nil, errors.New("123"))
 		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
 		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
 		w := httptest.NewRecorder()
@@ -56,6 +58,74 @@ func TestGetOrders(t *testing.T) {
 	})
 }
 
+func TestGetOrders_Paged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := mocks.NewMockServiceGet(ctrl)
+	h := handler.NewGet(mockService)
+
+	newRequest := func(target string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		return req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
+	}
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cursor := models.OrderCursor{UploadedAt: time.Date(2025, 2, 1, 10, 0, 0, 0, time.UTC), Number: "12345"}
+
+	t.Run("first page", func(t *testing.T) {
+		mockService.EXPECT().GetOrders(models.OrderQuery{
+			Username:     "testuser",
+			Statuses:     []models.OrderStatus{models.StatusNew, models.StatusProcessing, models.StatusProcessed},
+			UploadedFrom: &from,
+			Limit:        2,
+		}).Return([]dto.OrderResponseItem{{Number: "67890"}, {Number: "12345"}}, &cursor, nil)
+
+		w := httptest.NewRecorder()
+		h.Orders(w, newRequest("/api/user/orders?limit=2&status=new,processing&status=PROCESSED&from=2025-01-01T00:00:00Z"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, cursor.Encode(), w.Header().Get(handler.NextCursorHeader))
+
+		link := w.Header().Get("Link")
+		assert.Contains(t, link, `rel="next"`)
+		assert.Contains(t, link, "cursor="+cursor.Encode())
+		assert.Contains(t, link, "limit=2")
+	})
+
+	t.Run("next page", func(t *testing.T) {
+		mockService.EXPECT().GetOrders(models.OrderQuery{
+			Username:  "testuser",
+			Ascending: true,
+			After:     &cursor,
+			Limit:     100,
+		}).Return([]dto.OrderResponseItem{{Number: "11111"}}, nil, nil)
+
+		w := httptest.NewRecorder()
+		h.Orders(w, newRequest("/api/user/orders?sort=uploaded_at&cursor="+cursor.Encode()))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Link"))
+	})
+
+	for _, target := range []string{
+		"/api/user/orders?limit=0",
+		"/api/user/orders?limit=1001",
+		"/api/user/orders?limit=ten",
+		"/api/user/orders?status=DONE",
+		"/api/user/orders?from=yesterday",
+		"/api/user/orders?sort=number",
+		"/api/user/orders?cursor=!!!",
+	} {
+		t.Run(target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.Orders(w, newRequest(target))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
 func TestBalance(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -64,7 +134,7 @@ func TestBalance(t *testing.T) {
 	h := handler.NewGet(mockService)
 
 	t.Run("success", func(t *testing.T) {
-		mockService.EXPECT().GetBalance("testuser").Return(dto.BalanceResponce{Current: 100.0}, nil)
+		mockService.EXPECT().GetBalance("testuser").Return(dto.BalanceResponce{Current: models.NewAmount(100)}, nil)
 		req := httptest.NewRequest(http.MethodGet, "/balance", nil)
 		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
 		w := httptest.NewRecorder()
@@ -93,7 +163,7 @@ func TestWithdrawals(t *testing.T) {
 	h := handler.NewGet(mockService)
 
 	t.Run("success", func(t *testing.T) {
-		mockService.EXPECT().GetWithdrawals("testuser").Return([]dto.WithdrawalResponseItem{{Order: "12345", Sum: 50.0}}, nil)
+		mockService.EXPECT().GetWithdrawals("testuser").Return([]dto.WithdrawalResponseItem{{Order: "12345", Sum: models.NewAmount(50)}}, nil)
 		req := httptest.NewRequest(http.MethodGet, "/withdrawals", nil)
 		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
 		w := httptest.NewRecorder()
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/breaker"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
+)
+
+type CircuitState interface {
+	State() breaker.State
+}
+
+type HealthHandler struct {
+	circuit CircuitState
+}
+
+func NewHealth(circuit CircuitState) *HealthHandler {
+	return &HealthHandler{
+		circuit: circuit,
+	}
+}
+
+// Health reports the service status. The service keeps serving users while the
+// accrual system is unavailable, so an open circuit is reported as degraded.
+func (hh *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	state := hh.circuit.State()
+
+	res := dto.HealthResponse{Status: "ok", AccrualCircuit: state.String()}
+	if state != breaker.StateClosed {
+		res.Status = "degraded"
+	}
+
+	response, err := json.Marshal(res)
+	if err != nil {
+		slog.Error("Health Marshal error", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, writeErr := w.Write(response)
+	if writeErr != nil {
+		slog.Error("writeErr error", slog.String("error", writeErr.Error()))
+	}
+}
@@ -0,0 +1,45 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/breaker"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestHealth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCircuit := mocks.NewMockCircuitState(ctrl)
+	h := handler.NewHealth(mockCircuit)
+
+	t.Run("ok", func(t *testing.T) {
+		mockCircuit.EXPECT().State().Return(breaker.StateClosed)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+		w := httptest.NewRecorder()
+
+		h.Health(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"ok","accrual_circuit":"closed"}`, w.Body.String())
+	})
+
+	t.Run("degraded", func(t *testing.T) {
+		mockCircuit.EXPECT().State().Return(breaker.StateOpen)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+		w := httptest.NewRecorder()
+
+		h.Health(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"degraded","accrual_circuit":"open"}`, w.Body.String())
+	})
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
 )
 
 type malformedRequest struct {
@@ -56,6 +59,10 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 			msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
 			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
 
+		case errors.Is(err, models.ErrInvalidAmount):
+			msg := fmt.Sprintf("Request body contains an invalid amount: %v", err)
+			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+
 		case errors.Is(err, io.EOF):
 			msg := "Request body must not be empty"
 			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
@@ -77,3 +84,12 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 
 	return nil
 }
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
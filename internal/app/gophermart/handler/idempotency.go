@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/pkg/middleware"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayHeader marks responses replayed from a previous request
+	IdempotentReplayHeader = "Idempotent-Replayed"
+	// idempotencyKeyTTL is how long a recorded response is replayed
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyKeyLease is how long a pending request holds the key. A key left pending
+	// by a request that died is taken over by a retry once the lease runs out.
+	idempotencyKeyLease  = 30 * time.Second
+	maxIdempotencyKeyLen = 255
+)
+
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey records a pending request holding the key for lease. When the
+	// key is already taken the stored response is returned and token is empty.
+	ReserveIdempotencyKey(ctx context.Context, username, key, requestHash string, ttl, lease time.Duration) (stored models.IdempotentResponse, token string, err error)
+	// CompleteIdempotencyKey and ReleaseIdempotencyKey do nothing once the reservation
+	// with token has been taken over by another request
+	CompleteIdempotencyKey(ctx context.Context, username, key, token string, res models.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, username, key, token string) error
+}
+
+type Idempotency struct {
+	store IdempotencyStore
+}
+
+func NewIdempotency(store IdempotencyStore) *Idempotency {
+	return &Idempotency{
+		store: store,
+	}
+}
+
+// Handler makes requests carrying an Idempotency-Key safe to retry. The response to
+// the first request is recorded per user and replayed for later requests with the
+// same key and body. Reusing a key with a different body is rejected with 422, a key
+// whose first request is still running with 409. Server errors and responses that
+// failed to be recorded release the key so the client may retry them. Requests without
+// the header are passed through.
+func (i *Idempotency) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		username, ok := r.Context().Value(middleware.UserContextKey).(string)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1048576))
+		if err != nil {
+			http.Error(w, "Request body must not be larger than 1MB", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(r, body)
+		stored, token, err := i.store.ReserveIdempotencyKey(r.Context(), username, key, hash, idempotencyKeyTTL, idempotencyKeyLease)
+		if err != nil {
+			slog.Error("ReserveIdempotencyKey error", slog.String("error", err.Error()))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if token == "" {
+			replay(w, stored, hash)
+			return
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				i.release(r.Context(), username, key, token)
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= http.StatusInternalServerError {
+			i.release(r.Context(), username, key, token)
+			return
+		}
+
+		// The response is recorded even if the client has gone away
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 3*time.Second)
+		defer cancel()
+
+		err = i.store.CompleteIdempotencyKey(ctx, username, key, token, models.IdempotentResponse{
+			RequestHash: hash,
+			StatusCode:  rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			// A pending key would answer retries with 409 until the lease runs out
+			slog.Error("Failed to record idempotent response", slog.String("key", key), slog.String("error", err.Error()))
+			i.release(ctx, username, key, token)
+		}
+	})
+}
+
+// release frees the key of a failed request, so that the client can retry it
+func (i *Idempotency) release(ctx context.Context, username, key, token string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 3*time.Second)
+	defer cancel()
+
+	err := i.store.ReleaseIdempotencyKey(ctx, username, key, token)
+	if err != nil {
+		slog.Error("Failed to release idempotency key", slog.String("key", key), slog.String("error", err.Error()))
+	}
+}
+
+// replay writes the stored response to a repeated request
+func replay(w http.ResponseWriter, stored models.IdempotentResponse, hash string) {
+	if stored.RequestHash != hash {
+		http.Error(w, "Idempotency-Key was used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !stored.Completed() {
+		http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set(IdempotentReplayHeader, "true")
+	w.WriteHeader(stored.StatusCode)
+	_, err := w.Write(stored.Body)
+	if err != nil {
+		slog.Error("writeErr error", slog.String("error", err.Error()))
+	}
+}
+
+// requestHash identifies the request a key was used with
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes the response through and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,159 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestIdempotency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockIdempotencyStore(ctrl)
+
+	var calls int
+	status := http.StatusOK
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("done"))
+	})
+	h := handler.NewIdempotency(mockStore).Handler(next)
+
+	newRequest := func(key, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
+		if key != "" {
+			req.Header.Set(handler.IdempotencyKeyHeader, key)
+		}
+		return req
+	}
+
+	body := `{"order":"2377225624","sum":751}`
+	var hash string
+
+	t.Run("first request is recorded", func(t *testing.T) {
+		calls = 0
+		mockStore.EXPECT().ReserveIdempotencyKey(gomock.Any(), "testuser", "key-1", gomock.Any(), 24*time.Hour, 30*time.Second).
+			DoAndReturn(func(_ context.Context, _, _, h string, _, _ time.Duration) (models.IdempotentResponse, string, error) {
+				hash = h
+				return models.IdempotentResponse{}, "token-1", nil
+			})
+		mockStore.EXPECT().CompleteIdempotencyKey(gomock.Any(), "testuser", "key-1", "token-1", gomock.Any()).
+			DoAndReturn(func(_ context.Context, _, _, _ string, res models.IdempotentResponse) error {
+				assert.Equal(t, hash, res.RequestHash)
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, "text/plain", res.ContentType)
+				assert.Equal(t, "done", string(res.Body))
+				return nil
+			})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("key-1", body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("replay returns the recorded response", func(t *testing.T) {
+		calls = 0
+		stored := models.IdempotentResponse{RequestHash: hash, StatusCode: http.StatusOK, ContentType: "text/plain", Body: []byte("done")}
+		mockStore.EXPECT().ReserveIdempotencyKey(gomock.Any(), "testuser", "key-1", hash, gomock.Any(), gomock.Any()).Return(stored, "", nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("key-1", body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "done", w.Body.String())
+		assert.Equal(t, "true", w.Header().Get(handler.IdempotentReplayHeader))
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("key reused with a different body", func(t *testing.T) {
+		calls = 0
+		stored := models.IdempotentResponse{RequestHash: hash, StatusCode: http.StatusOK}
+		mockStore.EXPECT().ReserveIdempotencyKey(gomock.Any(), "testuser", "key-1", gomock.Not(hash), gomock.Any(), gomock.Any()).Return(stored, "", nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("key-1", `{"order":"2377225624","sum":1}`))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("first request still in progress", func(t *testing.T) {
+		mockStore.EXPECT().ReserveIdempotencyKey(gomock.Any(), "testuser", "key-1", hash, gomock.Any(), gomock.Any()).Return(models.IdempotentResponse{RequestHash: hash}, "", nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("key-1", body))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("server error releases the key", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		defer func() { status = http.StatusOK }()
+
+		mockStore.EXPECT().ReserveIdempotencyKey(gomock.Any(), "testuser", "key-2", gomock.Any(), gomock.Any(), gomock.Any()).Return(models.IdempotentResponse{}, "token-2", nil)
+		mockStore.EXPECT().ReleaseIdempotencyKey(gomock.Any(), "testuser", "key-2", "token-2").Return(nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("key-2", body))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("failure to record the response releases the key", func(t *testing.T) {
+		mockStore.EXPECT().ReserveIdempotencyKey(gomock.Any(), "testuser", "key-3", gomock.Any(), gomock.Any(), gomock.Any()).Return(models.IdempotentResponse{}, "token-3", nil)
+		mockStore.EXPECT().CompleteIdempotencyKey(gomock.Any(), "testuser", "key-3", "token-3", gomock.Any()).Return(assert.AnError)
+		mockStore.EXPECT().ReleaseIdempotencyKey(gomock.Any(), "testuser", "key-3", "token-3").Return(nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("key-3", body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		calls = 0
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("", body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestIdempotency_PanicReleasesKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockIdempotencyStore(ctrl)
+	h := handler.NewIdempotency(mockStore).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+
+	mockStore.EXPECT().ReserveIdempotencyKey(gomock.Any(), "testuser", "key-1", gomock.Any(), gomock.Any(), gomock.Any()).Return(models.IdempotentResponse{}, "token-1", nil)
+	mockStore.EXPECT().ReleaseIdempotencyKey(gomock.Any(), "testuser", "key-1", "token-1").Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(`{}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
+	req.Header.Set(handler.IdempotencyKeyHeader, "key-1")
+
+	assert.PanicsWithValue(t, "handler failed", func() {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	})
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/worker"
+	"github.com/atinyakov/go-musthave-diploma/pkg/auth"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the timestamp and the request body signed
+	// with the shared secret
+	SignatureHeader = "X-Signature"
+	// SignatureTimestampHeader carries the Unix time in seconds the request was signed at
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	// signatureMaxAge is how far the signing time may be from the server clock, a captured
+	// request can't be replayed after that
+	signatureMaxAge = 5 * time.Minute
+)
+
+type AccrualUpdater interface {
+	ApplyAccrual(context.Context, dto.AccrualResponse) error
+}
+
+type InternalHandler struct {
+	updater AccrualUpdater
+	secret  []byte
+}
+
+func NewInternal(updater AccrualUpdater, secret string) *InternalHandler {
+	return &InternalHandler{
+		updater: updater,
+		secret:  []byte(secret),
+	}
+}
+
+// AccrualCallback accepts status changes pushed by the accrual system
+func (ih *InternalHandler) AccrualCallback(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1048576))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	timestamp := r.Header.Get(SignatureTimestampHeader)
+	if !freshTimestamp(timestamp, time.Now()) {
+		http.Error(w, "Signature timestamp is missing or stale", http.StatusUnauthorized)
+		return
+	}
+
+	if !auth.VerifySignature(ih.secret, timestamp, body, r.Header.Get(SignatureHeader)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var reqData dto.AccrualResponse
+	err = decodeJSONBody(w, r, &reqData)
+	if err != nil {
+		var mr *malformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.msg, mr.status)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = ih.updater.ApplyAccrual(r.Context(), reqData)
+	if errors.Is(err, worker.ErrInvalidAccrual) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrIllegalTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("Accrual callback error", slog.String("error", err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// freshTimestamp reports whether the Unix timestamp is within signatureMaxAge of now
+func freshTimestamp(timestamp string, now time.Time) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(sec, 0))
+	return age <= signatureMaxAge && age >= -signatureMaxAge
+}
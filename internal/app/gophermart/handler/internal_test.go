@@ -0,0 +1,139 @@
+package handler_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/worker"
+	"github.com/atinyakov/go-musthave-diploma/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccrualCallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	secret := "shared_secret"
+	mockUpdater := mocks.NewMockAccrualUpdater(ctrl)
+	h := handler.NewInternal(mockUpdater, secret)
+
+	body := []byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`)
+	accrual := dto.AccrualResponse{Order: "12345678903", Status: "PROCESSED", Accrual: models.NewAmount(500)}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	newSignedRequest := func(body []byte, timestamp, signature string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/internal/accrual/callback", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(handler.SignatureTimestampHeader, timestamp)
+		req.Header.Set(handler.SignatureHeader, signature)
+		return req
+	}
+	newRequest := func(body []byte, signature string) *http.Request {
+		return newSignedRequest(body, now, signature)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockUpdater.EXPECT().ApplyAccrual(gomock.Any(), accrual).Return(nil)
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(body, "sha256="+auth.Sign([]byte(secret), now, body)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(body, auth.Sign([]byte("other"), now, body)))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(body, ""))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("stale signature", func(t *testing.T) {
+		// The signature is valid, but the request was captured ten minutes ago
+		stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newSignedRequest(body, stale, auth.Sign([]byte(secret), stale, body)))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("timestamp not covered by the signature", func(t *testing.T) {
+		stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newSignedRequest(body, now, auth.Sign([]byte(secret), stale, body)))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("missing timestamp", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newSignedRequest(body, "", auth.Sign([]byte(secret), "", body)))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		bad := []byte(`{"order":`)
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(bad, auth.Sign([]byte(secret), now, bad)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid update", func(t *testing.T) {
+		mockUpdater.EXPECT().ApplyAccrual(gomock.Any(), accrual).Return(fmt.Errorf("%w: unknown status", worker.ErrInvalidAccrual))
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(body, auth.Sign([]byte(secret), now, body)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unknown order", func(t *testing.T) {
+		mockUpdater.EXPECT().ApplyAccrual(gomock.Any(), accrual).Return(fmt.Errorf("%w: 12345678903", repository.ErrOrderNotFound))
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(body, auth.Sign([]byte(secret), now, body)))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("illegal transition", func(t *testing.T) {
+		mockUpdater.EXPECT().ApplyAccrual(gomock.Any(), accrual).Return(fmt.Errorf("%w: from INVALID", models.ErrIllegalTransition))
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(body, auth.Sign([]byte(secret), now, body)))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mockUpdater.EXPECT().ApplyAccrual(gomock.Any(), accrual).Return(assert.AnError)
+
+		w := httptest.NewRecorder()
+		h.AccrualCallback(w, newRequest(body, auth.Sign([]byte(secret), now, body)))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
@@ -1,17 +1,21 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/service"
-	"github.com/atinyakov/go-musthave-diploma/pkg/auth"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
 	"github.com/atinyakov/go-musthave-diploma/pkg/middleware"
 )
 
@@ -22,13 +26,30 @@ type ServicePost interface {
 	CreateWidthraw(dto.WithdrawalRequest, string) error
 }
 
+type Sessions interface {
+	Start(ctx context.Context, username string) (session.Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (session.Tokens, error)
+	Revoke(ctx context.Context, sessionID string) error
+}
+
+type LoginLimiter interface {
+	// Attempt counts a login attempt as failed until it succeeds and returns how long
+	// attempts are rejected, 0 if this one is allowed
+	Attempt(ctx context.Context, login, ip string) (time.Duration, error)
+	Succeeded(ctx context.Context, login, ip string) error
+}
+
 type PostHandler struct {
-	service ServicePost
+	service  ServicePost
+	sessions Sessions
+	limiter  LoginLimiter
 }
 
-func NewPost(service ServicePost) *PostHandler {
+func NewPost(service ServicePost, sessions Sessions, limiter LoginLimiter) *PostHandler {
 	return &PostHandler{
-		service: service,
+		service:  service,
+		sessions: sessions,
+		limiter:  limiter,
 	}
 }
 
@@ -60,17 +81,15 @@ func (ph *PostHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.GenerateJWT(reqData.Login)
+	tokens, err := ph.sessions.Start(r.Context(), reqData.Login)
 	if err != nil {
+		slog.Error("Error starting session", slog.String("error", err.Error()))
+
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Authorization", "Bearer "+token)
-
-	w.WriteHeader(http.StatusOK)
-
+	writeTokens(w, tokens)
 }
 
 func (ph *PostHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -89,10 +108,22 @@ func (ph *PostHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Limiter errors are logged and don't block logins
+	ip := clientIP(r)
+	locked, err := ph.limiter.Attempt(r.Context(), reqData.Login, ip)
+	if err != nil {
+		slog.Error("Login limiter attempt failed", slog.String("error", err.Error()))
+	}
+	if locked > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.Seconds()))))
+		http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		return
+	}
+
 	isValid, err := ph.service.Login(reqData.Login, reqData.Password)
 	if err != nil {
 		if err.Error() == "invalid username or password" {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			http.Error(w, "invalid username or password", http.StatusUnauthorized)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -100,26 +131,73 @@ func (ph *PostHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if isValid {
-		// Generate JWT
-		token, err := auth.GenerateJWT(reqData.Login)
+		if err := ph.limiter.Succeeded(r.Context(), reqData.Login, ip); err != nil {
+			slog.Error("Failed to reset login attempts", slog.String("error", err.Error()))
+		}
+
+		tokens, err := ph.sessions.Start(r.Context(), reqData.Login)
 		if err != nil {
-			slog.Error("Error generating token", slog.String("error", err.Error()))
+			slog.Error("Error starting session", slog.String("error", err.Error()))
 
 			http.Error(w, "Error generating token", http.StatusInternalServerError)
 			return
 		}
 
-		// Respond with token
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Authorization", "Bearer "+token)
-
-		w.WriteHeader(http.StatusOK)
+		writeTokens(w, tokens)
 		return
 	}
 
 	http.Error(w, "Internal server error", http.StatusInternalServerError)
 }
 
+// TokenRefresh exchanges a refresh token for a new access and refresh token.
+// Presenting an already used refresh token revokes its session.
+func (ph *PostHandler) TokenRefresh(w http.ResponseWriter, r *http.Request) {
+	var reqData dto.RefreshRequest
+
+	err := decodeJSONBody(w, r, &reqData)
+	if err != nil {
+		var mr *malformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.msg, mr.status)
+			return
+		}
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := ph.sessions.Refresh(r.Context(), reqData.RefreshToken)
+	if errors.Is(err, session.ErrInvalidRefreshToken) || errors.Is(err, session.ErrRefreshTokenReused) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		slog.Error("Error refreshing token", slog.String("error", err.Error()))
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// Logout revokes the session of the access token
+func (ph *PostHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, _ := r.Context().Value(middleware.SessionContextKey).(string)
+
+	err := ph.sessions.Revoke(r.Context(), sessionID)
+	if err != nil {
+		slog.Error("Error revoking session", slog.String("error", err.Error()))
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (ph *PostHandler) Orders(w http.ResponseWriter, r *http.Request) {
 	username := r.Context().Value(middleware.UserContextKey).(string)
 
@@ -185,6 +263,16 @@ func (ph *PostHandler) BalanceWithdraw(w http.ResponseWriter, r *http.Request) {
 
 	err = ph.service.CreateWidthraw(reqData, username)
 
+	if errors.Is(err, repository.ErrInsufficientFunds) {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	}
+
+	if errors.Is(err, repository.ErrWithdrawalExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
 	if err != nil {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		w.Write([]byte(fmt.Sprintf("error balance widtdraw: %v", err)))
@@ -193,3 +281,27 @@ func (ph *PostHandler) BalanceWithdraw(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// writeTokens responds with the tokens of a session
+func writeTokens(w http.ResponseWriter, tokens session.Tokens) {
+	response, err := json.Marshal(dto.TokenResponse{
+		AccessToken:  tokens.Access,
+		RefreshToken: tokens.Refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+	})
+	if err != nil {
+		slog.Error("Tokens Marshal error", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Authorization", "Bearer "+tokens.Access)
+	w.WriteHeader(http.StatusOK)
+
+	_, writeErr := w.Write(response)
+	if writeErr != nil {
+		slog.Error("writeErr error", slog.String("error", writeErr.Error()))
+	}
+}
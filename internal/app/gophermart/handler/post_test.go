@@ -8,29 +8,36 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/service"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
 	"github.com/atinyakov/go-musthave-diploma/pkg/middleware"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 )
 
+var tokens = session.Tokens{Access: "token", Refresh: "refresh", ExpiresIn: 15 * time.Minute}
+
 func TestRegister(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockService := mocks.NewMockServicePost(ctrl)
-	h := handler.NewPost(mockService)
+	mockSessions := mocks.NewMockSessions(ctrl)
+	h := handler.NewPost(mockService, mockSessions, mocks.NewMockLoginLimiter(ctrl))
 
 	reqData := dto.UserRequest{Login: "testuser", Password: "password"}
 	reqBody, _ := json.Marshal(reqData)
 
 	t.Run("success", func(t *testing.T) {
 		mockService.EXPECT().Register(reqData.Login, reqData.Password).Return(nil)
+		mockSessions.EXPECT().Start(gomock.Any(), reqData.Login).Return(tokens, nil)
 
 		req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(reqBody))
 		req.Header.Set("Content-Type", "application/json")
@@ -39,7 +46,8 @@ func TestRegister(t *testing.T) {
 		h.Register(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
-		assert.Contains(t, w.Header().Get("Authorization"), "Bearer")
+		assert.Equal(t, "Bearer token", w.Header().Get("Authorization"))
+		assert.JSONEq(t, `{"access_token":"token","refresh_token":"refresh","token_type":"Bearer","expires_in":900}`, w.Body.String())
 	})
 
 	t.Run("user exists", func(t *testing.T) {
@@ -71,44 +79,73 @@ func TestLogin(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockService := mocks.NewMockServicePost(ctrl)
-	h := handler.NewPost(mockService)
+	mockSessions := mocks.NewMockSessions(ctrl)
+	mockLimiter := mocks.NewMockLoginLimiter(ctrl)
+	h := handler.NewPost(mockService, mockSessions, mockLimiter)
 
-	t.Run("valid login", func(t *testing.T) {
-		mockService.EXPECT().Login("testuser", "password").Return(true, nil)
-		reqData := dto.UserRequest{Login: "testuser", Password: "password"}
-		reqBody, _ := json.Marshal(reqData)
+	// httptest requests come from 192.0.2.1
+	const ip = "192.0.2.1"
 
+	newRequest := func() *http.Request {
+		reqBody, _ := json.Marshal(dto.UserRequest{Login: "testuser", Password: "password"})
 		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(reqBody))
 		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	t.Run("valid login", func(t *testing.T) {
+		mockLimiter.EXPECT().Attempt(gomock.Any(), "testuser", ip).Return(time.Duration(0), nil)
+		mockService.EXPECT().Login("testuser", "password").Return(true, nil)
+		mockLimiter.EXPECT().Succeeded(gomock.Any(), "testuser", ip).Return(nil)
+		mockSessions.EXPECT().Start(gomock.Any(), "testuser").Return(tokens, nil)
+
 		w := httptest.NewRecorder()
+		h.Login(w, newRequest())
 
-		h.Login(w, req)
 		assert.Equal(t, http.StatusOK, w.Code)
-		assert.Contains(t, w.Header().Get("Authorization"), "Bearer")
+		assert.Equal(t, "Bearer token", w.Header().Get("Authorization"))
+		assert.JSONEq(t, `{"access_token":"token","refresh_token":"refresh","token_type":"Bearer","expires_in":900}`, w.Body.String())
 	})
+
 	t.Run("invalid login", func(t *testing.T) {
+		mockLimiter.EXPECT().Attempt(gomock.Any(), "testuser", ip).Return(time.Duration(0), nil)
 		mockService.EXPECT().Login("testuser", "password").Return(false, errors.New("invalid username or password"))
-		reqData := dto.UserRequest{Login: "testuser", Password: "password"}
-		reqBody, _ := json.Marshal(reqData)
 
-		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(reqBody))
-		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
+		h.Login(w, newRequest())
 
-		h.Login(w, req)
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	})
 
+	t.Run("locked out", func(t *testing.T) {
+		mockLimiter.EXPECT().Attempt(gomock.Any(), "testuser", ip).Return(1500*time.Millisecond, nil)
+
+		w := httptest.NewRecorder()
+		h.Login(w, newRequest())
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("limiter error doesn't block logins", func(t *testing.T) {
+		mockLimiter.EXPECT().Attempt(gomock.Any(), "testuser", ip).Return(time.Duration(0), errors.New("db is down"))
+		mockService.EXPECT().Login("testuser", "password").Return(true, nil)
+		mockLimiter.EXPECT().Succeeded(gomock.Any(), "testuser", ip).Return(errors.New("db is down"))
+		mockSessions.EXPECT().Start(gomock.Any(), "testuser").Return(tokens, nil)
+
+		w := httptest.NewRecorder()
+		h.Login(w, newRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
 	t.Run("err", func(t *testing.T) {
+		mockLimiter.EXPECT().Attempt(gomock.Any(), "testuser", ip).Return(time.Duration(0), nil)
 		mockService.EXPECT().Login("testuser", "password").Return(false, errors.New("123"))
-		reqData := dto.UserRequest{Login: "testuser", Password: "password"}
-		reqBody, _ := json.Marshal(reqData)
 
-		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(reqBody))
-		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
+		h.Login(w, newRequest())
 
-		h.Login(w, req)
 		assert.Equal(t, http.StatusInternalServerError, w.Code)
 	})
 }
@@ -118,7 +155,8 @@ func TestPostOrders(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockService := mocks.NewMockServicePost(ctrl)
-	h := handler.NewPost(mockService)
+	mockSessions := mocks.NewMockSessions(ctrl)
+	h := handler.NewPost(mockService, mockSessions, mocks.NewMockLoginLimiter(ctrl))
 
 	t.Run("valid order", func(t *testing.T) {
 		mockService.EXPECT().CreateOrder(12345, "testuser").Return(nil)
@@ -181,10 +219,11 @@ func TestBalanceWithdraw(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockService := mocks.NewMockServicePost(ctrl)
-	h := handler.NewPost(mockService)
+	mockSessions := mocks.NewMockSessions(ctrl)
+	h := handler.NewPost(mockService, mockSessions, mocks.NewMockLoginLimiter(ctrl))
 
 	t.Run("valid withdraw", func(t *testing.T) {
-		withdrawReq := dto.WithdrawalRequest{Order: "12345", Sum: 100}
+		withdrawReq := dto.WithdrawalRequest{Order: "12345", Sum: models.NewAmount(100)}
 		mockService.EXPECT().CreateWidthraw(withdrawReq, "testuser").Return(nil)
 		reqBody, _ := json.Marshal(withdrawReq)
 
@@ -198,7 +237,7 @@ func TestBalanceWithdraw(t *testing.T) {
 	})
 
 	t.Run("invalid withdraw", func(t *testing.T) {
-		withdrawReq := dto.WithdrawalRequest{Order: "12345", Sum: 100}
+		withdrawReq := dto.WithdrawalRequest{Order: "12345", Sum: models.NewAmount(100)}
 		mockService.EXPECT().CreateWidthraw(withdrawReq, "testuser").Return(errors.New("123"))
 		reqBody, _ := json.Marshal(withdrawReq)
 
@@ -210,8 +249,51 @@ func TestBalanceWithdraw(t *testing.T) {
 		h.BalanceWithdraw(w, req)
 		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 	})
+
+	t.Run("insufficient funds", func(t *testing.T) {
+		withdrawReq := dto.WithdrawalRequest{Order: "12345", Sum: models.NewAmount(100)}
+		mockService.EXPECT().CreateWidthraw(withdrawReq, "testuser").Return(repository.ErrInsufficientFunds)
+		reqBody, _ := json.Marshal(withdrawReq)
+
+		req := httptest.NewRequest(http.MethodPost, "/withdraw", bytes.NewBuffer(reqBody))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		h.BalanceWithdraw(w, req)
+		assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	})
+
+	t.Run("already withdrawn", func(t *testing.T) {
+		withdrawReq := dto.WithdrawalRequest{Order: "12345", Sum: models.NewAmount(100)}
+		mockService.EXPECT().CreateWidthraw(withdrawReq, "testuser").Return(repository.ErrWithdrawalExists)
+		reqBody, _ := json.Marshal(withdrawReq)
+
+		req := httptest.NewRequest(http.MethodPost, "/withdraw", bytes.NewBuffer(reqBody))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		h.BalanceWithdraw(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	for _, sum := range []string{`"abc"`, `true`, `1e30`} {
+		t.Run("unparsable sum "+sum, func(t *testing.T) {
+			body := `{"order": "12345", "sum": ` + sum + `}`
+
+			req := httptest.NewRequest(http.MethodPost, "/withdraw", bytes.NewBufferString(body))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, "testuser"))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.BalanceWithdraw(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+
 	t.Run("invalid req", func(t *testing.T) {
-		withdrawReq := dto.BalanceResponce{Current: 12345, Withdrawn: 100}
+		withdrawReq := dto.BalanceResponce{Current: models.NewAmount(12345), Withdrawn: models.NewAmount(100)}
 		reqBody, _ := json.Marshal(withdrawReq)
 
 		req := httptest.NewRequest(http.MethodPost, "/withdraw", bytes.NewBuffer(reqBody))
@@ -223,3 +305,84 @@ func TestBalanceWithdraw(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func TestTokenRefresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSessions := mocks.NewMockSessions(ctrl)
+	h := handler.NewPost(mocks.NewMockServicePost(ctrl), mockSessions, mocks.NewMockLoginLimiter(ctrl))
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/token/refresh", bytes.NewBufferString(`{"refresh_token":"old"}`))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockSessions.EXPECT().Refresh(gomock.Any(), "old").Return(tokens, nil)
+
+		w := httptest.NewRecorder()
+		h.TokenRefresh(w, newRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "Bearer token", w.Header().Get("Authorization"))
+		assert.JSONEq(t, `{"access_token":"token","refresh_token":"refresh","token_type":"Bearer","expires_in":900}`, w.Body.String())
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		mockSessions.EXPECT().Refresh(gomock.Any(), "old").Return(session.Tokens{}, session.ErrInvalidRefreshToken)
+
+		w := httptest.NewRecorder()
+		h.TokenRefresh(w, newRequest())
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("reused token", func(t *testing.T) {
+		mockSessions.EXPECT().Refresh(gomock.Any(), "old").Return(session.Tokens{}, session.ErrRefreshTokenReused)
+
+		w := httptest.NewRecorder()
+		h.TokenRefresh(w, newRequest())
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		mockSessions.EXPECT().Refresh(gomock.Any(), "old").Return(session.Tokens{}, errors.New("db is down"))
+
+		w := httptest.NewRecorder()
+		h.TokenRefresh(w, newRequest())
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/token/refresh", bytes.NewBufferString(`{"refresh":`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		h.TokenRefresh(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSessions := mocks.NewMockSessions(ctrl)
+	h := handler.NewPost(mocks.NewMockServicePost(ctrl), mockSessions, mocks.NewMockLoginLimiter(ctrl))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/logout", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserContextKey, "testuser")
+	req = req.WithContext(context.WithValue(ctx, middleware.SessionContextKey, "s1"))
+
+	mockSessions.EXPECT().Revoke(gomock.Any(), "s1").Return(nil)
+
+	w := httptest.NewRecorder()
+	h.Logout(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
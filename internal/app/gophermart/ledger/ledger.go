@@ -0,0 +1,323 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+)
+
+// Kind is the reason of a posting
+type Kind string
+
+const (
+	KindAccrual    Kind = "ACCRUAL"
+	KindWithdrawal Kind = "WITHDRAWAL"
+	KindAdjustment Kind = "ADJUSTMENT"
+	KindReversal   Kind = "REVERSAL"
+)
+
+// System accounts are the counterparts of user accounts. Their balances are
+// derived from entries only, so that they don't become a row every posting locks.
+const (
+	AccountAccruals    = "system:accruals"
+	AccountWithdrawals = "system:withdrawals"
+	AccountAdjustments = "system:adjustments"
+)
+
+const (
+	systemPrefix = "system:"
+	userPrefix   = "user:"
+)
+
+var ErrInvalidPosting = errors.New("invalid posting")
+var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrPostingNotFound = errors.New("posting not found")
+var ErrAlreadyReversed = errors.New("posting is already reversed")
+
+// Posting moves Amount points from one account to another. It is stored as
+// two immutable entries that sum up to zero.
+type Posting struct {
+	Kind        Kind
+	Reference   string
+	Description string
+	From        string
+	To          string
+	Amount      models.Amount
+}
+
+// UserAccount returns the name of the account holding the points of username
+func UserAccount(username string) string {
+	return userPrefix + username
+}
+
+// IsSystem reports whether account is a system account
+func IsSystem(account string) bool {
+	return strings.HasPrefix(account, systemPrefix)
+}
+
+// Post stores p within tx and updates the balance snapshots of the user accounts.
+// The user accounts are locked until tx ends, a user account can't go below zero.
+func Post(ctx context.Context, tx *sql.Tx, p Posting) (int64, error) {
+	if p.Amount <= 0 || p.From == p.To || p.From == "" || p.To == "" {
+		return 0, fmt.Errorf("%w: %s %v from %q to %q", ErrInvalidPosting, p.Kind, p.Amount, p.From, p.To)
+	}
+
+	// Accounts are locked in name order so that concurrent postings can't deadlock
+	accounts := []string{p.From, p.To}
+	sort.Strings(accounts)
+
+	balances := make(map[string]models.Amount, len(accounts))
+	for _, account := range accounts {
+		if IsSystem(account) {
+			continue
+		}
+
+		balance, err := LockBalance(ctx, tx, account)
+		if err != nil {
+			return 0, err
+		}
+		balances[account] = balance
+	}
+
+	if balance, ok := balances[p.From]; ok && balance < p.Amount {
+		return 0, ErrInsufficientFunds
+	}
+
+	var id int64
+	err := tx.QueryRowContext(ctx, "SELECT nextval('ledger_posting_seq')").Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger_entries (posting_id, account, amount, kind, reference, description)
+		VALUES ($1, $2, $3, $4, $5, $6), ($1, $7, $8, $4, $5, $6);`,
+		id, p.From, -p.Amount, p.Kind, p.Reference, p.Description, p.To, p.Amount)
+	if err != nil {
+		return 0, err
+	}
+
+	for account := range balances {
+		amount := p.Amount
+		if account == p.From {
+			amount = -amount
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE ledger_accounts SET balance = balance + $2, updated_at = CURRENT_TIMESTAMP WHERE name = $1", account, amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// LockBalance returns the balance snapshot of account, creating the account if needed.
+// The account row stays locked until tx ends.
+func LockBalance(ctx context.Context, tx *sql.Tx, account string) (models.Amount, error) {
+	_, err := tx.ExecContext(ctx, "INSERT INTO ledger_accounts (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", account)
+	if err != nil {
+		return 0, err
+	}
+
+	var balance models.Amount
+	err = tx.QueryRowContext(ctx, "SELECT balance FROM ledger_accounts WHERE name = $1 FOR UPDATE", account).Scan(&balance)
+	return balance, err
+}
+
+// Ledger runs corrections and reconciliation outside of other transactions
+type Ledger struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+// Adjust credits a positive or debits a negative amount to the account of username
+func (l *Ledger) Adjust(ctx context.Context, username string, amount models.Amount, reason string) (int64, error) {
+	p := Posting{
+		Kind:        KindAdjustment,
+		Description: reason,
+		From:        AccountAdjustments,
+		To:          UserAccount(username),
+		Amount:      amount,
+	}
+	if amount < 0 {
+		p.From, p.To, p.Amount = p.To, p.From, -amount
+	}
+
+	return l.inTx(ctx, func(tx *sql.Tx) (int64, error) {
+		return Post(ctx, tx, p)
+	})
+}
+
+// Reverse cancels a posting with an opposite one. A posting is reversed at most once
+// and reversals can't be reversed themselves.
+func (l *Ledger) Reverse(ctx context.Context, postingID int64, reason string) (int64, error) {
+	return l.inTx(ctx, func(tx *sql.Tx) (int64, error) {
+		rows, err := tx.QueryContext(ctx, "SELECT account, amount, kind FROM ledger_entries WHERE posting_id = $1", postingID)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+
+		p := Posting{Kind: KindReversal, Reference: strconv.FormatInt(postingID, 10), Description: reason}
+		var kind Kind
+		for rows.Next() {
+			var account string
+			var amount models.Amount
+			if err := rows.Scan(&account, &amount, &kind); err != nil {
+				return 0, err
+			}
+
+			if amount > 0 {
+				p.From, p.Amount = account, amount
+			} else {
+				p.To = account
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		if p.From == "" {
+			return 0, fmt.Errorf("%w: %d", ErrPostingNotFound, postingID)
+		}
+		if kind == KindReversal {
+			return 0, fmt.Errorf("%w: posting %d is a reversal", ErrInvalidPosting, postingID)
+		}
+
+		var reversed bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM ledger_entries WHERE kind = $1 AND reference = $2)", KindReversal, p.Reference).Scan(&reversed)
+		if err != nil {
+			return 0, err
+		}
+		if reversed {
+			return 0, fmt.Errorf("%w: %d", ErrAlreadyReversed, postingID)
+		}
+
+		return Post(ctx, tx, p)
+	})
+}
+
+func (l *Ledger) inTx(ctx context.Context, f func(tx *sql.Tx) (int64, error)) (int64, error) {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	id, err := f(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Mismatch is an inconsistency found by Reconcile
+type Mismatch struct {
+	// Account is set when the balance snapshot differs from the sum of the account entries
+	Account string
+	// PostingID is set when the entries of a posting don't sum up to zero
+	PostingID int64
+	Expected  models.Amount
+	Actual    models.Amount
+}
+
+// Reconcile compares the balance snapshots of user accounts with the sums of their
+// entries and checks that every posting is balanced
+func (l *Ledger) Reconcile(ctx context.Context) ([]Mismatch, error) {
+	res := make([]Mismatch, 0)
+
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT a.name, COALESCE(SUM(e.amount), 0), a.balance
+		FROM ledger_accounts a
+		LEFT JOIN ledger_entries e ON e.account = a.name
+		WHERE a.name NOT LIKE 'system:%'
+		GROUP BY a.name, a.balance
+		HAVING a.balance <> COALESCE(SUM(e.amount), 0);`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Mismatch
+		if err := rows.Scan(&m.Account, &m.Expected, &m.Actual); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	postings, err := l.db.QueryContext(ctx, `
+		SELECT posting_id, SUM(amount)
+		FROM ledger_entries
+		GROUP BY posting_id
+		HAVING SUM(amount) <> 0;`)
+	if err != nil {
+		return nil, err
+	}
+	defer postings.Close()
+
+	for postings.Next() {
+		var m Mismatch
+		if err := postings.Scan(&m.PostingID, &m.Actual); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	if err := postings.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// StartReconciler runs Reconcile every interval and logs the mismatches until ctx is done.
+// A zero or negative interval disables the reconciliation.
+func (l *Ledger) StartReconciler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		slog.Info("Ledger reconciliation is disabled", slog.Duration("interval", interval))
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mismatches, err := l.Reconcile(ctx)
+			if err != nil {
+				slog.Error("ledger reconciliation failed", slog.String("error", err.Error()))
+				continue
+			}
+
+			for _, m := range mismatches {
+				slog.Error("ledger is out of balance",
+					slog.String("account", m.Account),
+					slog.Int64("posting_id", m.PostingID),
+					slog.String("expected", m.Expected.String()),
+					slog.String("actual", m.Actual.String()))
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock, *Ledger) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db, mock, New(db)
+}
+
+func expectLockBalance(mock sqlmock.Sqlmock, account string, balance string) {
+	mock.ExpectExec("INSERT INTO ledger_accounts \\(name\\) VALUES \\(\\$1\\) ON CONFLICT \\(name\\) DO NOTHING").
+		WithArgs(account).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT balance FROM ledger_accounts WHERE name = \\$1 FOR UPDATE").
+		WithArgs(account).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(balance))
+}
+
+func TestPost_CreditsUserAccount(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+
+	mock.ExpectBegin()
+	expectLockBalance(mock, "user:alice", "0")
+	mock.ExpectQuery("SELECT nextval\\('ledger_posting_seq'\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(42))
+	mock.ExpectExec("INSERT INTO ledger_entries \\(posting_id, account, amount, kind, reference, description\\) VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5, \\$6\\), \\(\\$1, \\$7, \\$8, \\$4, \\$5, \\$6\\)").
+		WithArgs(int64(42), AccountAccruals, models.NewAmount(-500), KindAccrual, "12345678903", "", "user:alice", models.NewAmount(500)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE ledger_accounts SET balance = balance \\+ \\$2, updated_at = CURRENT_TIMESTAMP WHERE name = \\$1").
+		WithArgs("user:alice", models.NewAmount(500)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+
+	id, err := Post(context.Background(), tx, Posting{
+		Kind:      KindAccrual,
+		Reference: "12345678903",
+		From:      AccountAccruals,
+		To:        UserAccount("alice"),
+		Amount:    models.NewAmount(500),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPost_RejectsOverdraft(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+
+	mock.ExpectBegin()
+	expectLockBalance(mock, "user:alice", "10")
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+
+	_, err = Post(context.Background(), tx, Posting{Kind: KindWithdrawal, From: UserAccount("alice"), To: AccountWithdrawals, Amount: models.AmountFromFloat(10.5)})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPost_RejectsInvalidPosting(t *testing.T) {
+	_, err := Post(context.Background(), nil, Posting{Kind: KindAdjustment, From: AccountAdjustments, To: UserAccount("alice")})
+	assert.ErrorIs(t, err, ErrInvalidPosting)
+
+	_, err = Post(context.Background(), nil, Posting{Kind: KindAdjustment, From: UserAccount("alice"), To: UserAccount("alice"), Amount: 1})
+	assert.ErrorIs(t, err, ErrInvalidPosting)
+}
+
+func TestAdjust_NegativeAmountDebitsUser(t *testing.T) {
+	_, mock, l := setupMockDB(t)
+
+	mock.ExpectBegin()
+	expectLockBalance(mock, "user:alice", "100")
+	mock.ExpectQuery("SELECT nextval").
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO ledger_entries").
+		WithArgs(int64(7), "user:alice", models.NewAmount(-30), KindAdjustment, "", "duplicate accrual", AccountAdjustments, models.NewAmount(30)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE ledger_accounts SET balance").
+		WithArgs("user:alice", models.NewAmount(-30)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	id, err := l.Adjust(context.Background(), "alice", models.NewAmount(-30), "duplicate accrual")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReverse(t *testing.T) {
+	_, mock, l := setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT account, amount, kind FROM ledger_entries WHERE posting_id = \\$1").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"account", "amount", "kind"}).
+			AddRow(AccountAccruals, "-500", KindAccrual).
+			AddRow("user:alice", "500", KindAccrual))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM ledger_entries WHERE kind = \\$1 AND reference = \\$2\\)").
+		WithArgs(KindReversal, "42").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	expectLockBalance(mock, "user:alice", "500")
+	mock.ExpectQuery("SELECT nextval").
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(43))
+	mock.ExpectExec("INSERT INTO ledger_entries").
+		WithArgs(int64(43), "user:alice", models.NewAmount(-500), KindReversal, "42", "wrong order", AccountAccruals, models.NewAmount(500)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE ledger_accounts SET balance").
+		WithArgs("user:alice", models.NewAmount(-500)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	id, err := l.Reverse(context.Background(), 42, "wrong order")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(43), id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReverse_Errors(t *testing.T) {
+	_, mock, l := setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT account, amount, kind FROM ledger_entries").
+		WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"account", "amount", "kind"}))
+	mock.ExpectRollback()
+
+	_, err := l.Reverse(context.Background(), 1, "")
+	assert.ErrorIs(t, err, ErrPostingNotFound)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT account, amount, kind FROM ledger_entries").
+		WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"account", "amount", "kind"}).
+			AddRow(AccountAccruals, "-5", KindAccrual).
+			AddRow("user:alice", "5", KindAccrual))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(KindReversal, "2").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	_, err = l.Reverse(context.Background(), 2, "")
+	assert.ErrorIs(t, err, ErrAlreadyReversed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReconcile(t *testing.T) {
+	_, mock, l := setupMockDB(t)
+
+	mock.ExpectQuery("SELECT a.name, COALESCE\\(SUM\\(e.amount\\), 0\\), a.balance FROM ledger_accounts a").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "entries", "balance"}).AddRow("user:alice", "100.00", "120.00"))
+	mock.ExpectQuery("SELECT posting_id, SUM\\(amount\\) FROM ledger_entries GROUP BY posting_id").
+		WillReturnRows(sqlmock.NewRows([]string{"posting_id", "sum"}).AddRow(int64(9), "3.00"))
+
+	mismatches, err := l.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Mismatch{
+		{Account: "user:alice", Expected: models.NewAmount(100), Actual: models.NewAmount(120)},
+		{PostingID: 9, Actual: models.NewAmount(3)},
+	}, mismatches)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartReconciler_DisabledByNonPositiveInterval(t *testing.T) {
+	_, mock, l := setupMockDB(t)
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		// It would block until ctx is done or panic in time.NewTicker if it started
+		l.StartReconciler(context.Background(), interval)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
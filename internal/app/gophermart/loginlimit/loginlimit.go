@@ -0,0 +1,112 @@
+package loginlimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Store keeps the login attempts per key
+type Store interface {
+	// Attempt checks and counts an attempt for key in one step. While key is locked the
+	// attempt is not counted and the rest of the lock is returned. Otherwise the attempt
+	// is counted and key is locked for lockFor(attempts), where attempts counts them since
+	// the last reset, and 0 is returned. The count restarts after window without attempts.
+	Attempt(ctx context.Context, key string, window time.Duration, lockFor func(attempts int) time.Duration) (time.Duration, error)
+	// Refund takes back an attempt that turned out not to be a failure and lifts the lock
+	// it set. Attempts are only allowed while key isn't locked, so the lock can only have
+	// been set by that attempt or one made in parallel with it.
+	Refund(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+type Options struct {
+	// LoginAttempts failed attempts for a login are allowed before it is locked
+	LoginAttempts int
+	// IPAttempts failed attempts from an address are allowed before it is locked,
+	// more than for a login as many users may share an address
+	IPAttempts int
+	// Lockout is the first lockout, it doubles with every further failure
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// Window is how long failures are remembered without further failures
+	Window time.Duration
+}
+
+// Limiter locks out logins and client addresses after repeated failed attempts
+type Limiter struct {
+	store Store
+	opts  Options
+}
+
+func New(store Store, opts Options) *Limiter {
+	if opts.LoginAttempts <= 0 {
+		opts.LoginAttempts = 5
+	}
+	if opts.IPAttempts <= 0 {
+		opts.IPAttempts = 20
+	}
+	if opts.Lockout <= 0 {
+		opts.Lockout = time.Minute
+	}
+	if opts.MaxLockout < opts.Lockout {
+		opts.MaxLockout = max(opts.Lockout, time.Hour)
+	}
+	if opts.Window <= 0 {
+		opts.Window = max(opts.MaxLockout, time.Hour)
+	}
+
+	return &Limiter{store: store, opts: opts}
+}
+
+// Attempt counts an attempt to log in as login from ip before the password is checked
+// and returns how long attempts are rejected, 0 if this one is allowed. Checking and
+// counting in one step keeps a burst of parallel guesses from all passing the check
+// before any of them is recorded as a failure.
+func (l *Limiter) Attempt(ctx context.Context, login, ip string) (time.Duration, error) {
+	locked, err := l.store.Attempt(ctx, loginKey(login), l.opts.Window, l.lockFor(l.opts.LoginAttempts))
+	if err != nil || locked > 0 {
+		return locked, err
+	}
+
+	locked, err = l.store.Attempt(ctx, ipKey(ip), l.opts.Window, l.lockFor(l.opts.IPAttempts))
+	if locked > 0 {
+		// The attempt is rejected, so it doesn't count against the login either
+		return locked, errors.Join(err, l.store.Refund(ctx, loginKey(login)))
+	}
+
+	return locked, err
+}
+
+// Succeeded resets the counter of the login after a successful login and takes the
+// attempt back from the counter of the address. The address counter is not reset,
+// otherwise logging into an own account between guesses would keep an address from
+// ever being locked.
+func (l *Limiter) Succeeded(ctx context.Context, login, ip string) error {
+	return errors.Join(l.store.Reset(ctx, loginKey(login)), l.store.Refund(ctx, ipKey(ip)))
+}
+
+// lockFor returns the lockout after the given number of attempts: none for the first
+// free ones, then Lockout doubling with every attempt up to MaxLockout
+func (l *Limiter) lockFor(free int) func(attempts int) time.Duration {
+	return func(attempts int) time.Duration {
+		if attempts <= free {
+			return 0
+		}
+
+		d := l.opts.Lockout
+		for i := free + 1; i < attempts && d < l.opts.MaxLockout; i++ {
+			d *= 2
+		}
+
+		return min(d, l.opts.MaxLockout)
+	}
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
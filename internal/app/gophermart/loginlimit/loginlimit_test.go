@@ -0,0 +1,155 @@
+package loginlimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a settable time source for MemoryStore
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newTestLimiter(opts Options) (*Limiter, *clock) {
+	c := &clock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = c.Now
+	return New(store, opts), c
+}
+
+// fail makes n attempts that are allowed, as if they failed
+func fail(t *testing.T, l *Limiter, n int, login, ip string) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		locked, err := l.Attempt(context.Background(), login, ip)
+		require.NoError(t, err)
+		require.Zero(t, locked, "attempt %d", i+1)
+	}
+}
+
+func TestLimiter_ExponentialLockout(t *testing.T) {
+	ctx := context.Background()
+	l, c := newTestLimiter(Options{LoginAttempts: 3, IPAttempts: 100, Lockout: time.Second, MaxLockout: 5 * time.Second})
+
+	// The attempt after the free ones is allowed and locks the login
+	fail(t, l, 4, "testuser", "192.0.2.1")
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		locked, err := l.Attempt(ctx, "testuser", "192.0.2.1")
+		require.NoError(t, err)
+		assert.Equal(t, want, locked, "lockout %d", i+1)
+
+		// Rejected attempts are not counted, the next one after the lock doubles it
+		c.now = c.now.Add(locked)
+		fail(t, l, 1, "testuser", "192.0.2.1")
+	}
+
+	// Another login from the same address is not locked
+	fail(t, l, 1, "other", "192.0.2.1")
+}
+
+func TestLimiter_PerIP(t *testing.T) {
+	ctx := context.Background()
+	l, _ := newTestLimiter(Options{LoginAttempts: 100, IPAttempts: 2, Lockout: time.Minute})
+
+	// Guessing a different login every time still locks the address
+	for _, login := range []string{"a", "b", "c"} {
+		fail(t, l, 1, login, "192.0.2.1")
+	}
+
+	locked, err := l.Attempt(ctx, "d", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, locked)
+
+	fail(t, l, 1, "d", "192.0.2.2")
+}
+
+func TestLimiter_LockExpiresAndWindow(t *testing.T) {
+	ctx := context.Background()
+	l, c := newTestLimiter(Options{LoginAttempts: 1, IPAttempts: 100, Lockout: time.Minute, MaxLockout: time.Hour, Window: 2 * time.Hour})
+
+	fail(t, l, 2, "testuser", "192.0.2.1")
+
+	c.now = c.now.Add(30 * time.Second)
+	locked, err := l.Attempt(ctx, "testuser", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, locked)
+
+	// Failing right after the lock expires doubles the lockout
+	c.now = c.now.Add(time.Minute)
+	fail(t, l, 1, "testuser", "192.0.2.1")
+	locked, _ = l.Attempt(ctx, "testuser", "192.0.2.1")
+	assert.Equal(t, 2*time.Minute, locked)
+
+	// After a quiet window the count starts over
+	c.now = c.now.Add(3 * time.Hour)
+	fail(t, l, 2, "testuser", "192.0.2.1")
+	locked, _ = l.Attempt(ctx, "testuser", "192.0.2.1")
+	assert.Equal(t, time.Minute, locked)
+}
+
+func TestLimiter_SuccessResets(t *testing.T) {
+	ctx := context.Background()
+	l, _ := newTestLimiter(Options{LoginAttempts: 2, IPAttempts: 100, Lockout: time.Minute})
+
+	fail(t, l, 2, "testuser", "192.0.2.1")
+	fail(t, l, 1, "testuser", "192.0.2.1")
+	require.NoError(t, l.Succeeded(ctx, "testuser", "192.0.2.1"))
+
+	// The counter starts over, so the next failures are free again
+	fail(t, l, 3, "testuser", "192.0.2.1")
+}
+
+func TestLimiter_SuccessKeepsIPCounter(t *testing.T) {
+	ctx := context.Background()
+	l, _ := newTestLimiter(Options{LoginAttempts: 100, IPAttempts: 2, Lockout: time.Minute})
+
+	// Logging into an own account between guesses doesn't reset the address, and the
+	// successful logins themselves don't count against it
+	for _, login := range []string{"a", "b"} {
+		fail(t, l, 1, login, "192.0.2.1")
+		fail(t, l, 1, "attacker", "192.0.2.1")
+		require.NoError(t, l.Succeeded(ctx, "attacker", "192.0.2.1"))
+	}
+
+	fail(t, l, 1, "c", "192.0.2.1")
+	locked, err := l.Attempt(ctx, "d", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, locked)
+}
+
+func TestLimiter_ParallelAttempts(t *testing.T) {
+	ctx := context.Background()
+	l, _ := newTestLimiter(Options{LoginAttempts: 3, IPAttempts: 100, Lockout: time.Minute})
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locked, err := l.Attempt(ctx, "testuser", "192.0.2.1")
+			assert.NoError(t, err)
+			if locked == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A burst gets no more attempts than sequential guesses do
+	assert.Equal(t, 4, allowed)
+}
@@ -0,0 +1,92 @@
+package loginlimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	attempts    int
+	lastAttempt time.Time
+	lockedUntil time.Time
+}
+
+// MemoryStore keeps the counters in process memory. They are neither shared between
+// replicas nor kept across restarts.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	// purged is when stale keys were last removed
+	purged time.Time
+	now    func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Attempt(_ context.Context, key string, window time.Duration, lockFor func(attempts int) time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.purge(now, window)
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &entry{}
+		s.entries[key] = e
+	}
+
+	if locked := e.lockedUntil.Sub(now); locked > 0 {
+		return locked, nil
+	}
+
+	if now.Sub(e.lastAttempt) > window {
+		e.attempts = 0
+	}
+
+	e.attempts++
+	e.lastAttempt = now
+	e.lockedUntil = now.Add(lockFor(e.attempts))
+
+	return 0, nil
+}
+
+func (s *MemoryStore) Refund(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.attempts > 0 {
+		e.attempts--
+		e.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// purge forgets the keys whose attempts are older than window and that aren't locked.
+// It runs at most once a minute, so that a flood of attempts doesn't make each one slower.
+func (s *MemoryStore) purge(now time.Time, window time.Duration) {
+	if now.Sub(s.purged) < time.Minute {
+		return
+	}
+	s.purged = now
+
+	for key, e := range s.entries {
+		if now.Sub(e.lastAttempt) > window && !e.lockedUntil.After(now) {
+			delete(s.entries, key)
+		}
+	}
+}
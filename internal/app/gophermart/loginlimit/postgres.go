@@ -0,0 +1,101 @@
+package loginlimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// PostgresStore keeps the counters in the login_attempts table, so that all replicas
+// share them. Its failures and last_failure columns count every attempt that wasn't
+// refunded.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	purged time.Time
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Attempt(ctx context.Context, key string, window time.Duration, lockFor func(attempts int) time.Duration) (time.Duration, error) {
+	s.purge(ctx, window)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// The upsert checks the lock and counts the attempt in one statement. It holds the
+	// row lock until commit, so parallel attempts see the lock set below.
+	count := `
+	INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 1, CURRENT_TIMESTAMP)
+	ON CONFLICT (key) DO UPDATE
+	SET failures = CASE
+			WHEN login_attempts.locked_until > CURRENT_TIMESTAMP THEN login_attempts.failures
+			WHEN login_attempts.last_failure < CURRENT_TIMESTAMP - make_interval(secs => $2) THEN 1
+			ELSE login_attempts.failures + 1 END,
+		last_failure = CASE WHEN login_attempts.locked_until > CURRENT_TIMESTAMP THEN login_attempts.last_failure ELSE CURRENT_TIMESTAMP END
+	RETURNING failures, COALESCE(EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)), 0);`
+
+	var attempts int
+	var seconds float64
+	err = tx.QueryRowContext(ctx, count, key, window.Seconds()).Scan(&attempts, &seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	if locked := toDuration(seconds); locked > 0 {
+		return locked, tx.Commit()
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE login_attempts SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2) WHERE key = $1",
+		key, lockFor(attempts).Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, tx.Commit()
+}
+
+func (s *PostgresStore) Refund(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_attempts SET failures = GREATEST(failures - 1, 0), locked_until = NULL WHERE key = $1", key)
+	return err
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
+
+// purge deletes the keys whose attempts are older than window and that aren't locked.
+// It runs at most once a minute per replica.
+func (s *PostgresStore) purge(ctx context.Context, window time.Duration) {
+	s.mu.Lock()
+	if time.Since(s.purged) < time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.purged = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM login_attempts
+	WHERE last_failure < CURRENT_TIMESTAMP - make_interval(secs => $1)
+	AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`, window.Seconds())
+	if err != nil {
+		slog.Error("Failed to purge login attempts", slog.String("error", err.Error()))
+	}
+}
+
+func toDuration(seconds float64) time.Duration {
+	return max(time.Duration(seconds*float64(time.Second)), 0)
+}
@@ -0,0 +1,87 @@
+package loginlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const countAttempt = "INSERT INTO login_attempts \\(key, failures, last_failure\\) VALUES \\(\\$1, 1, CURRENT_TIMESTAMP\\) ON CONFLICT \\(key\\) DO UPDATE " +
+	"SET failures = CASE WHEN login_attempts.locked_until > CURRENT_TIMESTAMP THEN login_attempts.failures .* " +
+	"RETURNING failures, COALESCE\\(EXTRACT\\(EPOCH FROM \\(locked_until - CURRENT_TIMESTAMP\\)\\), 0\\)"
+
+func TestPostgresStore_Attempt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewPostgresStore(db)
+
+	mock.ExpectExec("DELETE FROM login_attempts WHERE last_failure < CURRENT_TIMESTAMP - make_interval\\(secs => \\$1\\)").
+		WithArgs(3600.0).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(countAttempt).
+		WithArgs("login:testuser", 3600.0).
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "seconds"}).AddRow(4, "-5.5"))
+	mock.ExpectExec("UPDATE login_attempts SET locked_until = CURRENT_TIMESTAMP \\+ make_interval\\(secs => \\$2\\) WHERE key = \\$1").
+		WithArgs("login:testuser", 120.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var attempts int
+	locked, err := store.Attempt(context.Background(), "login:testuser", time.Hour, func(n int) time.Duration {
+		attempts = n
+		return 2 * time.Minute
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, attempts)
+	assert.Zero(t, locked, "the attempt that sets the lock is allowed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_AttemptWhileLocked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewPostgresStore(db)
+	store.purged = time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(countAttempt).
+		WithArgs("ip:192.0.2.1", 3600.0).
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "seconds"}).AddRow(21, "90.000000"))
+	mock.ExpectCommit()
+
+	locked, err := store.Attempt(context.Background(), "ip:192.0.2.1", time.Hour, func(int) time.Duration {
+		t.Error("a rejected attempt doesn't extend the lock")
+		return 0
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, locked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_RefundAndReset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := NewPostgresStore(db)
+
+	mock.ExpectExec("UPDATE login_attempts SET failures = GREATEST\\(failures - 1, 0\\), locked_until = NULL WHERE key = \\$1").
+		WithArgs("ip:192.0.2.1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM login_attempts WHERE key = \\$1").
+		WithArgs("login:testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, store.Refund(context.Background(), "ip:192.0.2.1"))
+	require.NoError(t, store.Reset(context.Background(), "login:testuser"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
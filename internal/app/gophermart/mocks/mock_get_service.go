@@ -56,19 +56,20 @@ func (mr *MockServiceGetMockRecorder) GetBalance(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockServiceGet)(nil).GetBalance), arg0)
 }
 
-// GetOrdersByUsername mocks base method.
-func (m *MockServiceGet) GetOrdersByUsername(arg0 string) ([]models.Order, error) {
+// GetOrders mocks base method.
+func (m *MockServiceGet) GetOrders(arg0 models.OrderQuery) ([]dto.OrderResponseItem, *models.OrderCursor, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrdersByUsername", arg0)
-	ret0, _ := ret[0].([]models.Order)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "GetOrders", arg0)
+	ret0, _ := ret[0].([]dto.OrderResponseItem)
+	ret1, _ := ret[1].(*models.OrderCursor)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
-// GetOrdersByUsername indicates an expected call of GetOrdersByUsername.
-func (mr *MockServiceGetMockRecorder) GetOrdersByUsername(arg0 any) *gomock.Call {
+// GetOrders indicates an expected call of GetOrders.
+func (mr *MockServiceGetMockRecorder) GetOrders(arg0 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersByUsername", reflect.TypeOf((*MockServiceGet)(nil).GetOrdersByUsername), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockServiceGet)(nil).GetOrders), arg0)
 }
 
 // GetWithdrawals mocks base method.
@@ -0,0 +1,55 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/app/gophermart/handler/health.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/app/gophermart/handler/health.go -destination=internal/app/gophermart/mocks/mock_health_service.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	reflect "reflect"
+
+	breaker "github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/breaker"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockCircuitState is a mock of CircuitState interface.
+type MockCircuitState struct {
+	ctrl     *gomock.Controller
+	recorder *MockCircuitStateMockRecorder
+	isgomock struct{}
+}
+
+// MockCircuitStateMockRecorder is the mock recorder for MockCircuitState.
+type MockCircuitStateMockRecorder struct {
+	mock *MockCircuitState
+}
+
+// NewMockCircuitState creates a new mock instance.
+func NewMockCircuitState(ctrl *gomock.Controller) *MockCircuitState {
+	mock := &MockCircuitState{ctrl: ctrl}
+	mock.recorder = &MockCircuitStateMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCircuitState) EXPECT() *MockCircuitStateMockRecorder {
+	return m.recorder
+}
+
+// State mocks base method.
+func (m *MockCircuitState) State() breaker.State {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "State")
+	ret0, _ := ret[0].(breaker.State)
+	return ret0
+}
+
+// State indicates an expected call of State.
+func (mr *MockCircuitStateMockRecorder) State() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "State", reflect.TypeOf((*MockCircuitState)(nil).State))
+}
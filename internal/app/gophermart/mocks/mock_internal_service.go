@@ -0,0 +1,56 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/app/gophermart/handler/internal.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/app/gophermart/handler/internal.go -destination=internal/app/gophermart/mocks/mock_internal_service.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	dto "github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockAccrualUpdater is a mock of AccrualUpdater interface.
+type MockAccrualUpdater struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccrualUpdaterMockRecorder
+	isgomock struct{}
+}
+
+// MockAccrualUpdaterMockRecorder is the mock recorder for MockAccrualUpdater.
+type MockAccrualUpdaterMockRecorder struct {
+	mock *MockAccrualUpdater
+}
+
+// NewMockAccrualUpdater creates a new mock instance.
+func NewMockAccrualUpdater(ctrl *gomock.Controller) *MockAccrualUpdater {
+	mock := &MockAccrualUpdater{ctrl: ctrl}
+	mock.recorder = &MockAccrualUpdaterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccrualUpdater) EXPECT() *MockAccrualUpdaterMockRecorder {
+	return m.recorder
+}
+
+// ApplyAccrual mocks base method.
+func (m *MockAccrualUpdater) ApplyAccrual(arg0 context.Context, arg1 dto.AccrualResponse) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyAccrual", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ApplyAccrual indicates an expected call of ApplyAccrual.
+func (mr *MockAccrualUpdaterMockRecorder) ApplyAccrual(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyAccrual", reflect.TypeOf((*MockAccrualUpdater)(nil).ApplyAccrual), arg0, arg1)
+}
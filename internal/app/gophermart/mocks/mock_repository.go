@@ -71,19 +71,33 @@ func (mr *MockRepositoryMockRecorder) CreateUser(arg0, arg1 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockRepository)(nil).CreateUser), arg0, arg1)
 }
 
-// GetOrdersByUsername mocks base method.
-func (m *MockRepository) GetOrdersByUsername(ctx context.Context, username string) ([]models.Order, error) {
+// CreateWithdrawal mocks base method.
+func (m *MockRepository) CreateWithdrawal(ctx context.Context, w models.Withdrawal) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrdersByUsername", ctx, username)
+	ret := m.ctrl.Call(m, "CreateWithdrawal", ctx, w)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateWithdrawal indicates an expected call of CreateWithdrawal.
+func (mr *MockRepositoryMockRecorder) CreateWithdrawal(ctx, w any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWithdrawal", reflect.TypeOf((*MockRepository)(nil).CreateWithdrawal), ctx, w)
+}
+
+// GetOrders mocks base method.
+func (m *MockRepository) GetOrders(ctx context.Context, q models.OrderQuery) ([]models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrders", ctx, q)
 	ret0, _ := ret[0].([]models.Order)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetOrdersByUsername indicates an expected call of GetOrdersByUsername.
-func (mr *MockRepositoryMockRecorder) GetOrdersByUsername(ctx, username any) *gomock.Call {
+// GetOrders indicates an expected call of GetOrders.
+func (mr *MockRepositoryMockRecorder) GetOrders(ctx, q any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersByUsername", reflect.TypeOf((*MockRepository)(nil).GetOrdersByUsername), ctx, username)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockRepository)(nil).GetOrders), ctx, q)
 }
 
 // GetPasswordHashByUsername mocks base method.
@@ -102,11 +116,11 @@ func (mr *MockRepositoryMockRecorder) GetPasswordHashByUsername(arg0 any) *gomoc
 }
 
 // GetUserBalanceAndWithdrawals mocks base method.
-func (m *MockRepository) GetUserBalanceAndWithdrawals(ctx context.Context, username string) (float64, float64, error) {
+func (m *MockRepository) GetUserBalanceAndWithdrawals(ctx context.Context, username string) (models.Amount, models.Amount, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserBalanceAndWithdrawals", ctx, username)
-	ret0, _ := ret[0].(float64)
-	ret1, _ := ret[1].(float64)
+	ret0, _ := ret[0].(models.Amount)
+	ret1, _ := ret[1].(models.Amount)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
@@ -118,10 +132,10 @@ func (mr *MockRepositoryMockRecorder) GetUserBalanceAndWithdrawals(ctx, username
 }
 
 // GetWithdrawalsByUsername mocks base method.
-func (m *MockRepository) GetWithdrawalsByUsername(ctx context.Context, username string) ([]models.Order, error) {
+func (m *MockRepository) GetWithdrawalsByUsername(ctx context.Context, username string) ([]models.Withdrawal, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetWithdrawalsByUsername", ctx, username)
-	ret0, _ := ret[0].([]models.Order)
+	ret0, _ := ret[0].([]models.Withdrawal)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -131,3 +145,17 @@ func (mr *MockRepositoryMockRecorder) GetWithdrawalsByUsername(ctx, username any
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawalsByUsername", reflect.TypeOf((*MockRepository)(nil).GetWithdrawalsByUsername), ctx, username)
 }
+
+// UpdatePasswordHash mocks base method.
+func (m *MockRepository) UpdatePasswordHash(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePasswordHash", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePasswordHash indicates an expected call of UpdatePasswordHash.
+func (mr *MockRepositoryMockRecorder) UpdatePasswordHash(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePasswordHash", reflect.TypeOf((*MockRepository)(nil).UpdatePasswordHash), arg0, arg1)
+}
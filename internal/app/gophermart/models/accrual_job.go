@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AccrualJob is an order waiting to be polled in the accrual system
+type AccrualJob struct {
+	Order Order
+	// Attempts is the number of failed polls since the last successful one
+	Attempts int
+	// Age is the time since the order was uploaded, measured by the clock that set
+	// Order.UploadedAt, so that the time zones of the database and the server don't matter
+	Age time.Duration
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// Amount is a number of loyalty points kept in hundredths, so that sums of
+// accruals and withdrawals are exact. It is written to JSON as a plain number
+// and to the database as NUMERIC(12,2).
+type Amount int64
+
+// amountScale is the number of hundredths in one point
+const amountScale = 100
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// decimalPattern matches decimal numbers with an optional exponent. big.Rat also parses
+// fractions and hex, which aren't amounts. The exponent is kept short, so that a huge one
+// isn't expanded before the range check.
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d{1,4})?$`)
+
+// NewAmount returns an Amount of units whole points
+func NewAmount(units int64) Amount {
+	return Amount(units * amountScale)
+}
+
+// AmountFromFloat converts f rounding it to hundredths
+func AmountFromFloat(f float64) Amount {
+	return Amount(math.Round(f * amountScale))
+}
+
+// ParseAmount parses a decimal number such as "500", "729.98" or "1e3". Digits past
+// hundredths are rounded half away from zero without going through float64.
+func ParseAmount(s string) (Amount, error) {
+	if !decimalPattern.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	r.Mul(r, big.NewRat(amountScale, 1))
+
+	// Rounding half away from zero is truncation of r ± 1/2
+	half := big.NewRat(1, 2)
+	if r.Sign() < 0 {
+		half.Neg(half)
+	}
+	r.Add(r, half)
+
+	n := new(big.Int).Quo(r.Num(), r.Denom())
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+
+	return Amount(n.Int64()), nil
+}
+
+// String formats a without trailing zeros: 500, 500.5, 500.55
+func (a Amount) String() string {
+	sign := ""
+	u := uint64(a)
+	if a < 0 {
+		sign = "-"
+		u = uint64(-a)
+	}
+
+	units, cents := u/amountScale, u%amountScale
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+// Float64 returns a as a float, for logging and metrics only
+func (a Amount) Float64() float64 {
+	return float64(a) / amountScale
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	// Amounts sent as strings are accepted as well
+	if s, err := strconv.Unquote(string(b)); err == nil {
+		b = []byte(s)
+	}
+
+	v, err := ParseAmount(string(b))
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
+
+// Scan reads NUMERIC values, which lib/pq returns as text
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	case int64:
+		*a = NewAmount(v)
+	case float64:
+		*a = AmountFromFloat(v)
+	default:
+		return fmt.Errorf("%w: can't scan %T", ErrInvalidAmount, src)
+	}
+	return nil
+}
+
+func (a *Amount) scanString(s string) error {
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
+
+// Value writes a as a decimal string, so that NUMERIC columns get the exact value
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
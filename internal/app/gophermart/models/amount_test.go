@@ -0,0 +1,107 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"500", 50000},
+		{"729.98", 72998},
+		{"0.1", 10},
+		{"1e3", 100000},
+		{"0.005", 1},
+		{"0.0049", 0},
+		{"-0.005", -1},
+		{"-12.345", -1235},
+		{"+1.", 100},
+		{".5", 50},
+		{"25E-1", 250},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAmount(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, in := range []string{"ten", "1e30", "1e99999", "1/3", "0x10", "0b1", "1_000", "", ".", "1e", "+-1", " 1"} {
+		t.Run("invalid "+in, func(t *testing.T) {
+			_, err := ParseAmount(in)
+			assert.ErrorIs(t, err, ErrInvalidAmount)
+		})
+	}
+}
+
+func TestAmount_String(t *testing.T) {
+	assert.Equal(t, "500", NewAmount(500).String())
+	assert.Equal(t, "500.5", Amount(50050).String())
+	assert.Equal(t, "500.55", Amount(50055).String())
+	assert.Equal(t, "0.05", Amount(5).String())
+	assert.Equal(t, "-0.5", Amount(-50).String())
+}
+
+func TestAmount_JSON(t *testing.T) {
+	var v struct {
+		Current   Amount `json:"current"`
+		Withdrawn Amount `json:"withdrawn"`
+	}
+
+	require.NoError(t, json.Unmarshal([]byte(`{"current":500.5,"withdrawn":42}`), &v))
+	assert.Equal(t, Amount(50050), v.Current)
+	assert.Equal(t, NewAmount(42), v.Withdrawn)
+
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"current":500.5,"withdrawn":42}`, string(b))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"current":true}`), &v))
+}
+
+func TestAmount_Scan(t *testing.T) {
+	var a Amount
+	require.NoError(t, a.Scan([]byte("123.45")))
+	assert.Equal(t, Amount(12345), a)
+
+	require.NoError(t, a.Scan(0.1+0.2))
+	assert.Equal(t, Amount(30), a)
+
+	require.NoError(t, a.Scan(nil))
+	assert.Equal(t, Amount(0), a)
+
+	v, err := Amount(12345).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "123.45", v)
+}
+
+func TestAmount_SumsAreExact(t *testing.T) {
+	var balance Amount
+	var float float64
+
+	// A thousand accruals of 0.1 followed by a thousand withdrawals of 0.07
+	for i := 0; i < 1000; i++ {
+		a, err := ParseAmount("0.1")
+		require.NoError(t, err)
+		balance += a
+		float += 0.1
+	}
+	for i := 0; i < 1000; i++ {
+		w, err := ParseAmount("0.07")
+		require.NoError(t, err)
+		balance -= w
+		float -= 0.07
+	}
+
+	assert.Equal(t, NewAmount(30), balance)
+	assert.Equal(t, "30", balance.String())
+	assert.NotEqual(t, 30.0, float, "float64 drifts, which is why amounts are fixed-point")
+}
@@ -0,0 +1,15 @@
+package models
+
+// IdempotentResponse is the response recorded for an Idempotency-Key.
+// StatusCode is 0 while the first request with the key is still being handled.
+type IdempotentResponse struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Completed reports whether the response has been recorded
+func (r IdempotentResponse) Completed() bool {
+	return r.StatusCode != 0
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"time"
 )
 
@@ -13,10 +14,39 @@ const (
 	StatusProcessed  OrderStatus = "PROCESSED"
 )
 
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+// transitions lists the statuses an order may move to from each status.
+// INVALID and PROCESSED are final and have no outgoing transitions.
+var transitions = map[OrderStatus][]OrderStatus{
+	StatusNew:        {StatusNew, StatusProcessing, StatusInvalid, StatusProcessed},
+	StatusProcessing: {StatusProcessing, StatusInvalid, StatusProcessed},
+}
+
 type Order struct {
-	Username   string      `json:"string"`
+	Username   string      `json:"-"`
 	Number     string      `json:"number"`
 	Status     OrderStatus `json:"status"`
-	Accrual    float64     `json:"accrual"`
+	Accrual    Amount      `json:"accrual"`
 	UploadedAt time.Time   `json:"uploaded_at"`
 }
+
+// IsFinal reports whether the accrual system will not change the status anymore
+func (s OrderStatus) IsFinal() bool {
+	return s == StatusProcessed || s == StatusInvalid
+}
+
+// IsPollable reports whether the order still has to be polled in the accrual system
+func (s OrderStatus) IsPollable() bool {
+	return s == StatusNew || s == StatusProcessing
+}
+
+// CanTransitionTo reports whether an order with status s may be moved to next
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
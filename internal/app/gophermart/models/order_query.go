@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// OrderQuery selects orders of a user. Orders are sorted by upload time, newest first
+// unless Ascending is set, and by number among orders uploaded at the same time.
+type OrderQuery struct {
+	Username string
+	// Statuses keeps only orders in one of the statuses, all when empty
+	Statuses []OrderStatus
+	// UploadedFrom and UploadedTo bound uploaded_at as [UploadedFrom, UploadedTo)
+	UploadedFrom *time.Time
+	UploadedTo   *time.Time
+	Ascending    bool
+	// After continues the listing after the order the cursor points to
+	After *OrderCursor
+	// Limit is the maximum number of orders, 0 means no limit
+	Limit int
+}
+
+// OrderCursor is the position of an order in a listing. Keyset pagination is stable when
+// orders are uploaded between requests, unlike offsets.
+type OrderCursor struct {
+	UploadedAt time.Time
+	Number     string
+}
+
+// CursorOf returns the position of order
+func CursorOf(order Order) OrderCursor {
+	return OrderCursor{UploadedAt: order.UploadedAt, Number: order.Number}
+}
+
+// Encode returns the cursor as an opaque URL-safe string
+func (c OrderCursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.UploadedAt.Format(time.RFC3339Nano) + "|" + c.Number))
+}
+
+// ParseOrderCursor decodes a cursor made by Encode
+func ParseOrderCursor(s string) (OrderCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return OrderCursor{}, ErrInvalidCursor
+	}
+
+	at, number, ok := strings.Cut(string(b), "|")
+	if !ok || number == "" {
+		return OrderCursor{}, ErrInvalidCursor
+	}
+
+	uploadedAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return OrderCursor{}, ErrInvalidCursor
+	}
+
+	return OrderCursor{UploadedAt: uploadedAt, Number: number}, nil
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		want     bool
+	}{
+		{StatusNew, StatusProcessing, true},
+		{StatusNew, StatusProcessed, true},
+		{StatusNew, StatusInvalid, true},
+		{StatusProcessing, StatusProcessing, true},
+		{StatusProcessing, StatusProcessed, true},
+		{StatusProcessing, StatusNew, false},
+		{StatusProcessed, StatusNew, false},
+		{StatusProcessed, StatusProcessing, false},
+		{StatusInvalid, StatusProcessed, false},
+		{StatusNew, OrderStatus("REGISTERED"), false},
+		{OrderStatus(""), StatusProcessed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.from.CanTransitionTo(tt.to))
+		})
+	}
+}
+
+func TestOrderStatus_IsPollable(t *testing.T) {
+	assert.True(t, StatusNew.IsPollable())
+	assert.True(t, StatusProcessing.IsPollable())
+	assert.False(t, StatusInvalid.IsPollable())
+	assert.False(t, StatusProcessed.IsPollable())
+	assert.False(t, OrderStatus("").IsPollable())
+}
+
+func TestOrderCursor(t *testing.T) {
+	c := OrderCursor{UploadedAt: time.Date(2025, 2, 1, 10, 0, 0, 123456000, time.UTC), Number: "12345678903"}
+
+	parsed, err := ParseOrderCursor(c.Encode())
+	assert.NoError(t, err)
+	assert.True(t, c.UploadedAt.Equal(parsed.UploadedAt))
+	assert.Equal(t, c.Number, parsed.Number)
+
+	for _, s := range []string{"", "!!!", "bm8tc2VwYXJhdG9y", "eWVzdGVyZGF5fDEyMw"} {
+		_, err := ParseOrderCursor(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
@@ -0,0 +1,9 @@
+package models
+
+// Session is a login of a user. Its refresh tokens form one family: every refresh
+// replaces the token, and revoking the session invalidates all of them along with
+// the access tokens issued for it.
+type Session struct {
+	ID       string
+	Username string
+}
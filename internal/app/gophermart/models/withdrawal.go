@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// Withdrawal is a payment of an order with loyalty points
+type Withdrawal struct {
+	Username    string
+	Order       string
+	Sum         Amount
+	ProcessedAt time.Time
+}
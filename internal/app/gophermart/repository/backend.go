@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+)
+
+// Backend is everything the server keeps in the storage: the data of the service, the
+// accrual job queue, sessions and idempotency keys. Repository and MemoryRepository
+// implement it with the same semantics.
+type Backend interface {
+	CreateUser(username, password string) error
+	GetPasswordHashByUsername(username string) (string, error)
+	UpdatePasswordHash(username, passwordHash string) error
+
+	CreateOrder(ctx context.Context, newOrder models.Order) (*models.Order, bool, error)
+	GetOrders(ctx context.Context, q models.OrderQuery) ([]models.Order, error)
+	UpdateOrders(ctx context.Context, os []models.Order) error
+
+	ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error)
+	RescheduleAccrualJob(ctx context.Context, number string, delay time.Duration, reason string) error
+
+	CreateWithdrawal(ctx context.Context, w models.Withdrawal) error
+	GetWithdrawalsByUsername(ctx context.Context, username string) ([]models.Withdrawal, error)
+	GetUserBalanceAndWithdrawals(ctx context.Context, username string) (models.Amount, models.Amount, error)
+
+	ReserveIdempotencyKey(ctx context.Context, username, key, requestHash string, ttl, lease time.Duration) (models.IdempotentResponse, string, error)
+	CompleteIdempotencyKey(ctx context.Context, username, key, token string, res models.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, username, key, token string) error
+
+	CreateSession(ctx context.Context, s models.Session, refreshHash string, ttl time.Duration) error
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, ttl time.Duration) (models.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	SessionActive(ctx context.Context, id string) (bool, error)
+}
+
+var (
+	_ Backend = (*Repository)(nil)
+	_ Backend = (*MemoryRepository)(nil)
+)
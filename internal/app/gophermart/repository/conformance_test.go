@@ -0,0 +1,462 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/handler"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/repository"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/service"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The server hands a Backend to all of these
+var (
+	_ service.Repository       = repository.Backend(nil)
+	_ worker.Repo              = repository.Backend(nil)
+	_ session.Store            = repository.Backend(nil)
+	_ handler.IdempotencyStore = repository.Backend(nil)
+)
+
+func TestMemoryRepository_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) repository.Backend {
+		return repository.NewMemoryRepository()
+	})
+}
+
+func TestRepository_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) repository.Backend {
+		return repository.SetupPostgres(t)
+	})
+}
+
+// runConformance checks that a repository.Backend behaves like the others. The PostgreSQL database
+// is shared between runs, so every test works with its own users and orders and doesn't
+// assume the tables are empty.
+func runConformance(t *testing.T, newBackend func(t *testing.T) repository.Backend) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repository.Backend)
+	}{
+		{"users", testUsers},
+		{"orders", testOrders},
+		{"order listing", testOrderListing},
+		{"accrual updates", testAccrualUpdates},
+		{"withdrawals", testWithdrawals},
+		{"accrual jobs", testAccrualJobs},
+		{"idempotency keys", testIdempotencyKeys},
+		{"sessions", testSessions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newBackend(t))
+		})
+	}
+}
+
+var seq atomic.Int64
+
+// unique returns a name or order number no previous run has used
+func unique(prefix string) string {
+	return fmt.Sprintf("%s%d%d", prefix, time.Now().UnixNano(), seq.Add(1))
+}
+
+func newUser(t *testing.T, repo repository.Backend) string {
+	t.Helper()
+
+	username := unique("conformance-")
+	require.NoError(t, repo.CreateUser(username, "hash"))
+	return username
+}
+
+// credit processes a new order of the user with the accrual
+func credit(t *testing.T, repo repository.Backend, username string, accrual models.Amount) {
+	t.Helper()
+
+	ctx := context.Background()
+	number := unique("")
+
+	_, _, err := repo.CreateOrder(ctx, models.Order{Number: number, Username: username, Status: models.StatusNew})
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{{Number: number, Status: models.StatusProcessed, Accrual: accrual}}))
+}
+
+func testUsers(t *testing.T, repo repository.Backend) {
+	username := newUser(t, repo)
+
+	assert.ErrorIs(t, repo.CreateUser(username, "other"), repository.ErrUserExists)
+
+	hash, err := repo.GetPasswordHashByUsername(username)
+	require.NoError(t, err)
+	assert.Equal(t, "hash", hash)
+
+	require.NoError(t, repo.UpdatePasswordHash(username, "rehashed"))
+	hash, err = repo.GetPasswordHashByUsername(username)
+	require.NoError(t, err)
+	assert.Equal(t, "rehashed", hash)
+
+	unknown := unique("conformance-unknown-")
+	_, err = repo.GetPasswordHashByUsername(unknown)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+	assert.ErrorIs(t, repo.UpdatePasswordHash(unknown, "hash"), repository.ErrUserNotFound)
+}
+
+func testOrders(t *testing.T, repo repository.Backend) {
+	ctx := context.Background()
+	owner, other := newUser(t, repo), newUser(t, repo)
+	number := unique("")
+
+	created, exists, err := repo.CreateOrder(ctx, models.Order{Number: number, Username: owner, Status: models.StatusNew})
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, owner, created.Username)
+
+	// Uploading the number again reports the owner, so that the service tells a repeat
+	// from a conflict
+	for _, username := range []string{owner, other} {
+		stored, exists, err := repo.CreateOrder(ctx, models.Order{Number: number, Username: username, Status: models.StatusNew})
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, owner, stored.Username)
+		assert.Equal(t, models.StatusNew, stored.Status)
+	}
+
+	orders, err := repo.GetOrders(ctx, models.OrderQuery{Username: other})
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+
+	_, _, err = repo.CreateOrder(ctx, models.Order{Number: unique(""), Username: unique("conformance-unknown-"), Status: models.StatusNew})
+	assert.Error(t, err, "orders belong to existing users")
+}
+
+func testOrderListing(t *testing.T, repo repository.Backend) {
+	ctx := context.Background()
+	username := newUser(t, repo)
+
+	var numbers []string
+	for i := 0; i < 3; i++ {
+		number := unique("")
+		_, _, err := repo.CreateOrder(ctx, models.Order{Number: number, Username: username, Status: models.StatusNew})
+		require.NoError(t, err)
+		numbers = append(numbers, number)
+		// Distinct upload times keep the expected order independent of the tie-break
+		time.Sleep(2 * time.Millisecond)
+	}
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{{Number: numbers[1], Status: models.StatusInvalid}}))
+
+	numbersOf := func(orders []models.Order) []string {
+		res := make([]string, 0, len(orders))
+		for _, o := range orders {
+			res = append(res, o.Number)
+		}
+		return res
+	}
+
+	all, err := repo.GetOrders(ctx, models.OrderQuery{Username: username})
+	require.NoError(t, err)
+	assert.Equal(t, []string{numbers[2], numbers[1], numbers[0]}, numbersOf(all))
+
+	page, err := repo.GetOrders(ctx, models.OrderQuery{Username: username, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{numbers[2], numbers[1]}, numbersOf(page))
+
+	after := models.CursorOf(page[1])
+	page, err = repo.GetOrders(ctx, models.OrderQuery{Username: username, After: &after, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{numbers[0]}, numbersOf(page))
+
+	page, err = repo.GetOrders(ctx, models.OrderQuery{Username: username, Ascending: true, After: &after})
+	require.NoError(t, err)
+	assert.Equal(t, []string{numbers[2]}, numbersOf(page))
+
+	page, err = repo.GetOrders(ctx, models.OrderQuery{Username: username, Statuses: []models.OrderStatus{models.StatusNew}, Ascending: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{numbers[0], numbers[2]}, numbersOf(page))
+
+	from, to := all[1].UploadedAt, all[0].UploadedAt
+	page, err = repo.GetOrders(ctx, models.OrderQuery{Username: username, UploadedFrom: &from, UploadedTo: &to})
+	require.NoError(t, err)
+	assert.Equal(t, []string{numbers[1]}, numbersOf(page))
+}
+
+func testAccrualUpdates(t *testing.T, repo repository.Backend) {
+	ctx := context.Background()
+	username := newUser(t, repo)
+
+	processed, invalid := unique(""), unique("")
+	for _, number := range []string{processed, invalid} {
+		_, _, err := repo.CreateOrder(ctx, models.Order{Number: number, Username: username, Status: models.StatusNew})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{{Number: processed, Status: models.StatusProcessing}}))
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{
+		{Number: processed, Status: models.StatusProcessed, Accrual: models.NewAmount(500)},
+		{Number: invalid, Status: models.StatusInvalid},
+	}))
+
+	// Repeating a final status is a no-op and doesn't credit the accrual twice
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{{Number: processed, Status: models.StatusProcessed, Accrual: models.NewAmount(500)}}))
+
+	// Rejected updates don't stop the others
+	fresh := unique("")
+	_, _, err := repo.CreateOrder(ctx, models.Order{Number: fresh, Username: username, Status: models.StatusNew})
+	require.NoError(t, err)
+
+	err = repo.UpdateOrders(ctx, []models.Order{
+		{Number: invalid, Status: models.StatusProcessed, Accrual: models.NewAmount(100)},
+		{Number: unique("missing-"), Status: models.StatusProcessed},
+		{Number: fresh, Status: models.StatusProcessed, Accrual: models.AmountFromFloat(0.5)},
+	})
+	assert.ErrorIs(t, err, models.ErrIllegalTransition)
+	assert.ErrorIs(t, err, repository.ErrOrderNotFound)
+
+	balance, withdrawn, err := repo.GetUserBalanceAndWithdrawals(ctx, username)
+	require.NoError(t, err)
+	assert.Equal(t, models.AmountFromFloat(500.5), balance)
+	assert.Zero(t, withdrawn)
+
+	orders, err := repo.GetOrders(ctx, models.OrderQuery{Username: username})
+	require.NoError(t, err)
+	require.Len(t, orders, 3)
+	assert.Equal(t, models.Order{Username: username, Number: fresh, Status: models.StatusProcessed, Accrual: models.AmountFromFloat(0.5)}, withoutTime(orders[0]))
+	assert.Equal(t, models.Order{Username: username, Number: invalid, Status: models.StatusInvalid}, withoutTime(orders[1]))
+	assert.Equal(t, models.Order{Username: username, Number: processed, Status: models.StatusProcessed, Accrual: models.NewAmount(500)}, withoutTime(orders[2]))
+}
+
+func withoutTime(o models.Order) models.Order {
+	o.UploadedAt = time.Time{}
+	return o
+}
+
+func testWithdrawals(t *testing.T, repo repository.Backend) {
+	ctx := context.Background()
+	username := newUser(t, repo)
+
+	first, second := unique(""), unique("")
+	err := repo.CreateWithdrawal(ctx, models.Withdrawal{Username: username, Order: first, Sum: models.NewAmount(1)})
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	credit(t, repo, username, models.NewAmount(100))
+
+	// Zero and negative sums would credit points instead of withdrawing them
+	for _, sum := range []models.Amount{0, models.NewAmount(-1000)} {
+		err = repo.CreateWithdrawal(ctx, models.Withdrawal{Username: username, Order: unique(""), Sum: sum})
+		assert.ErrorIs(t, err, repository.ErrInvalidPosting)
+	}
+
+	balance, withdrawn, err := repo.GetUserBalanceAndWithdrawals(ctx, username)
+	require.NoError(t, err)
+	assert.Equal(t, models.NewAmount(100), balance)
+	assert.Zero(t, withdrawn)
+
+	require.NoError(t, repo.CreateWithdrawal(ctx, models.Withdrawal{Username: username, Order: first, Sum: models.NewAmount(60)}))
+	time.Sleep(2 * time.Millisecond)
+
+	err = repo.CreateWithdrawal(ctx, models.Withdrawal{Username: username, Order: first, Sum: models.NewAmount(1)})
+	assert.ErrorIs(t, err, repository.ErrWithdrawalExists)
+
+	err = repo.CreateWithdrawal(ctx, models.Withdrawal{Username: username, Order: second, Sum: models.AmountFromFloat(40.01)})
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	require.NoError(t, repo.CreateWithdrawal(ctx, models.Withdrawal{Username: username, Order: second, Sum: models.NewAmount(40)}))
+
+	balance, withdrawn, err = repo.GetUserBalanceAndWithdrawals(ctx, username)
+	require.NoError(t, err)
+	assert.Zero(t, balance)
+	assert.Equal(t, models.NewAmount(100), withdrawn)
+
+	withdrawals, err := repo.GetWithdrawalsByUsername(ctx, username)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 2)
+	assert.Equal(t, second, withdrawals[0].Order)
+	assert.Equal(t, models.NewAmount(40), withdrawals[0].Sum)
+	assert.Equal(t, first, withdrawals[1].Order)
+	assert.False(t, withdrawals[1].ProcessedAt.IsZero())
+
+	other := newUser(t, repo)
+	withdrawals, err = repo.GetWithdrawalsByUsername(ctx, other)
+	require.NoError(t, err)
+	assert.Empty(t, withdrawals)
+}
+
+func testAccrualJobs(t *testing.T, repo repository.Backend) {
+	ctx := context.Background()
+	username := newUser(t, repo)
+	number := unique("")
+
+	_, _, err := repo.CreateOrder(ctx, models.Order{Number: number, Username: username, Status: models.StatusNew})
+	require.NoError(t, err)
+
+	// Jobs of earlier runs may be due as well, so the limit is generous
+	claim := func() *models.AccrualJob {
+		jobs, err := repo.ClaimAccrualJobs(ctx, 10000, time.Hour)
+		require.NoError(t, err)
+		for _, job := range jobs {
+			if job.Order.Number == number {
+				return &job
+			}
+		}
+		return nil
+	}
+
+	job := claim()
+	require.NotNil(t, job)
+	assert.Equal(t, username, job.Order.Username)
+	assert.Zero(t, job.Attempts)
+	assert.InDelta(t, 0, job.Age.Seconds(), 60, "the age is measured by the clock that set uploaded_at")
+
+	assert.Nil(t, claim(), "claimed jobs are leased")
+
+	require.NoError(t, repo.RescheduleAccrualJob(ctx, number, 0, "accrual system unavailable"))
+	job = claim()
+	require.NotNil(t, job)
+	assert.Equal(t, 1, job.Attempts)
+
+	// A non-final update postpones the job by the poll delay with the attempts reset
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{{Number: number, Status: models.StatusProcessing}}))
+	assert.Nil(t, claim(), "orders still processing wait for the poll delay")
+
+	require.NoError(t, repo.RescheduleAccrualJob(ctx, number, 0, "accrual system unavailable"))
+	job = claim()
+	require.NotNil(t, job)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, models.StatusProcessing, job.Order.Status)
+
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{{Number: number, Status: models.StatusInvalid}}))
+	require.NoError(t, repo.RescheduleAccrualJob(ctx, number, 0, "late failure"))
+	assert.Nil(t, claim(), "orders with a final status are not polled")
+}
+
+func testIdempotencyKeys(t *testing.T, repo repository.Backend) {
+	ctx := context.Background()
+	username := newUser(t, repo)
+	key := unique("key-")
+
+	_, token, err := repo.ReserveIdempotencyKey(ctx, username, key, "hash", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	stored, taken, err := repo.ReserveIdempotencyKey(ctx, username, key, "other", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, taken)
+	assert.Equal(t, "hash", stored.RequestHash)
+	assert.False(t, stored.Completed())
+
+	response := models.IdempotentResponse{StatusCode: 200, ContentType: "application/json", Body: []byte(`{}`)}
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, username, key, token, response))
+
+	// Completed keys are kept on release
+	require.NoError(t, repo.ReleaseIdempotencyKey(ctx, username, key, token))
+	stored, taken, err = repo.ReserveIdempotencyKey(ctx, username, key, "hash", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, taken)
+	assert.Equal(t, models.IdempotentResponse{RequestHash: "hash", StatusCode: 200, ContentType: "application/json", Body: []byte(`{}`)}, stored)
+
+	// Keys are per user
+	other := newUser(t, repo)
+	_, token, err = repo.ReserveIdempotencyKey(ctx, other, key, "hash", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	// A released pending key can be reserved again
+	require.NoError(t, repo.ReleaseIdempotencyKey(ctx, other, key, token))
+	_, token, err = repo.ReserveIdempotencyKey(ctx, other, key, "retry", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	// Pending keys are taken over once their lease runs out
+	abandoned := unique("key-")
+	_, late, err := repo.ReserveIdempotencyKey(ctx, username, abandoned, "crashed", time.Hour, time.Millisecond)
+	require.NoError(t, err)
+	require.NotEmpty(t, late)
+
+	stored, taken, err = repo.ReserveIdempotencyKey(ctx, username, abandoned, "retry", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, taken, "the lease hasn't run out yet")
+	assert.Equal(t, "crashed", stored.RequestHash)
+
+	time.Sleep(10 * time.Millisecond)
+	_, token, err = repo.ReserveIdempotencyKey(ctx, username, abandoned, "retry", time.Hour, time.Hour)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	assert.NotEqual(t, late, token)
+
+	// The request that lost the key neither records its response nor releases the key
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, username, abandoned, late, models.IdempotentResponse{StatusCode: 402}))
+	require.NoError(t, repo.ReleaseIdempotencyKey(ctx, username, abandoned, late))
+	stored, taken, err = repo.ReserveIdempotencyKey(ctx, username, abandoned, "retry", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, taken)
+	assert.Equal(t, models.IdempotentResponse{RequestHash: "retry"}, stored)
+
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, username, abandoned, token, models.IdempotentResponse{StatusCode: 200}))
+	stored, _, err = repo.ReserveIdempotencyKey(ctx, username, abandoned, "retry", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 200, stored.StatusCode)
+
+	// Expired keys are taken over
+	time.Sleep(10 * time.Millisecond)
+	_, token, err = repo.ReserveIdempotencyKey(ctx, username, key, "new", time.Millisecond, time.Hour)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+}
+
+func testSessions(t *testing.T, repo repository.Backend) {
+	ctx := context.Background()
+	s := models.Session{ID: unique("session-"), Username: newUser(t, repo)}
+
+	require.NoError(t, repo.CreateSession(ctx, s, s.ID+"-first", time.Hour))
+
+	active, err := repo.SessionActive(ctx, s.ID)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	rotated, err := repo.RotateRefreshToken(ctx, s.ID+"-first", s.ID+"-second", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, s, rotated)
+
+	_, err = repo.RotateRefreshToken(ctx, s.ID+"-unknown", s.ID+"-third", time.Hour)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+	// Reusing a rotated token revokes the session along with its newer tokens
+	reused, err := repo.RotateRefreshToken(ctx, s.ID+"-first", s.ID+"-third", time.Hour)
+	assert.ErrorIs(t, err, session.ErrRefreshTokenReused)
+	assert.Equal(t, s, reused)
+
+	active, err = repo.SessionActive(ctx, s.ID)
+	require.NoError(t, err)
+	assert.False(t, active)
+
+	_, err = repo.RotateRefreshToken(ctx, s.ID+"-second", s.ID+"-third", time.Hour)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+	// Expired tokens are rejected without revoking the session
+	expiring := models.Session{ID: unique("session-"), Username: s.Username}
+	require.NoError(t, repo.CreateSession(ctx, expiring, expiring.ID+"-first", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = repo.RotateRefreshToken(ctx, expiring.ID+"-first", expiring.ID+"-second", time.Hour)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+	active, err = repo.SessionActive(ctx, expiring.ID)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	require.NoError(t, repo.RevokeSession(ctx, expiring.ID))
+	active, err = repo.SessionActive(ctx, expiring.ID)
+	require.NoError(t, err)
+	assert.False(t, active)
+
+	active, err = repo.SessionActive(ctx, unique("session-"))
+	require.NoError(t, err)
+	assert.False(t, active)
+}
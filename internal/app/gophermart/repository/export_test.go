@@ -0,0 +1,4 @@
+package repository
+
+// SetupPostgres lets the conformance suite in package repository_test use the test database
+var SetupPostgres = setupPostgres
@@ -0,0 +1,486 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
+)
+
+type memoryJob struct {
+	attempts      int
+	lastError     string
+	nextAttemptAt time.Time
+}
+
+type memoryIdempotencyKey struct {
+	response    models.IdempotentResponse
+	createdAt   time.Time
+	lockedUntil time.Time
+	token       string
+}
+
+type memorySession struct {
+	username string
+	revoked  bool
+}
+
+type memoryRefreshToken struct {
+	sessionID string
+	expiresAt time.Time
+	used      bool
+}
+
+type idempotencyKeyID struct {
+	username string
+	key      string
+}
+
+// MemoryRepository keeps all data in process memory with the same semantics as
+// Repository. Data is lost on restart, so it is meant for tests and demos.
+type MemoryRepository struct {
+	mu sync.Mutex
+
+	users map[string]string
+	// orders are kept in upload order
+	orders      []*models.Order
+	orderIndex  map[string]*models.Order
+	jobs        map[string]*memoryJob
+	withdrawals []models.Withdrawal
+	balances    map[string]models.Amount
+
+	idempotencyKeys map[idempotencyKeyID]*memoryIdempotencyKey
+	sessions        map[string]*memorySession
+	refreshTokens   map[string]*memoryRefreshToken
+
+	now func() time.Time
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		users:           make(map[string]string),
+		orderIndex:      make(map[string]*models.Order),
+		jobs:            make(map[string]*memoryJob),
+		balances:        make(map[string]models.Amount),
+		idempotencyKeys: make(map[idempotencyKeyID]*memoryIdempotencyKey),
+		sessions:        make(map[string]*memorySession),
+		refreshTokens:   make(map[string]*memoryRefreshToken),
+		now:             time.Now,
+	}
+}
+
+// timestamp returns the current time with the microsecond precision of PostgreSQL, so
+// that order cursors round trip the same way
+func (r *MemoryRepository) timestamp() time.Time {
+	return r.now().Truncate(time.Microsecond)
+}
+
+func (r *MemoryRepository) CreateUser(username, password string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[username]; ok {
+		return ErrUserExists
+	}
+
+	r.users[username] = password
+	return nil
+}
+
+func (r *MemoryRepository) GetPasswordHashByUsername(username string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash, ok := r.users[username]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	return hash, nil
+}
+
+// UpdatePasswordHash replaces the stored password hash of the user
+func (r *MemoryRepository) UpdatePasswordHash(username, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	r.users[username] = passwordHash
+	return nil
+}
+
+// CreateOrder stores the order unless its number is taken, in which case the stored
+// order is returned with exists set
+func (r *MemoryRepository) CreateOrder(_ context.Context, newOrder models.Order) (*models.Order, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.orderIndex[newOrder.Number]; ok {
+		order := *existing
+		return &order, true, nil
+	}
+
+	if _, ok := r.users[newOrder.Username]; !ok {
+		return nil, false, fmt.Errorf("%w: %s", ErrUserNotFound, newOrder.Username)
+	}
+
+	order := newOrder
+	order.UploadedAt = r.timestamp()
+	r.orders = append(r.orders, &order)
+	r.orderIndex[order.Number] = &order
+
+	// New orders are queued for polling in the accrual system
+	if order.Status == models.StatusNew {
+		r.jobs[order.Number] = &memoryJob{nextAttemptAt: order.UploadedAt}
+	}
+
+	created := order
+	return &created, false, nil
+}
+
+// GetOrders returns the orders selected by q, see Repository.GetOrders
+func (r *MemoryRepository) GetOrders(_ context.Context, q models.OrderQuery) ([]models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]models.Order, 0)
+	for _, o := range r.orders {
+		if o.Username != q.Username {
+			continue
+		}
+		if len(q.Statuses) > 0 && !slices.Contains(q.Statuses, o.Status) {
+			continue
+		}
+		if q.UploadedFrom != nil && o.UploadedAt.Before(*q.UploadedFrom) {
+			continue
+		}
+		if q.UploadedTo != nil && !o.UploadedAt.Before(*q.UploadedTo) {
+			continue
+		}
+		if q.After != nil {
+			c := compareOrderPosition(models.CursorOf(*o), *q.After)
+			if q.Ascending && c <= 0 || !q.Ascending && c >= 0 {
+				continue
+			}
+		}
+
+		res = append(res, *o)
+	}
+
+	slices.SortFunc(res, func(a, b models.Order) int {
+		c := compareOrderPosition(models.CursorOf(a), models.CursorOf(b))
+		if q.Ascending {
+			return c
+		}
+		return -c
+	})
+
+	if q.Limit > 0 && len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+
+	return res, nil
+}
+
+// compareOrderPosition orders positions by upload time and then by number, like the
+// (uploaded_at, number) row comparison in SQL
+func compareOrderPosition(a, b models.OrderCursor) int {
+	if c := a.UploadedAt.Compare(b.UploadedAt); c != 0 {
+		return c
+	}
+	switch {
+	case a.Number < b.Number:
+		return -1
+	case a.Number > b.Number:
+		return 1
+	}
+	return 0
+}
+
+// UpdateOrders applies accrual results with the checks of Repository.UpdateOrders
+func (r *MemoryRepository) UpdateOrders(_ context.Context, os []models.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rejected []error
+	for _, o := range os {
+		order, ok := r.orderIndex[o.Number]
+		if !ok {
+			rejected = append(rejected, fmt.Errorf("%w: %s", ErrOrderNotFound, o.Number))
+			continue
+		}
+
+		if order.Status == o.Status && order.Status.IsFinal() {
+			continue
+		}
+
+		if !order.Status.CanTransitionTo(o.Status) {
+			rejected = append(rejected, fmt.Errorf("%w: order %s from %s to %s", models.ErrIllegalTransition, o.Number, order.Status, o.Status))
+			continue
+		}
+
+		order.Status = o.Status
+		order.Accrual = o.Accrual
+
+		if o.Status == models.StatusProcessed && o.Accrual > 0 {
+			r.balances[order.Username] += o.Accrual
+		}
+
+		// Orders with a final status are not polled anymore, others are polled again after processingPollDelay
+		if o.Status.IsFinal() {
+			delete(r.jobs, o.Number)
+		} else if job, ok := r.jobs[o.Number]; ok {
+			*job = memoryJob{nextAttemptAt: r.now().Add(processingPollDelay)}
+		}
+	}
+
+	return errors.Join(rejected...)
+}
+
+// ClaimAccrualJobs returns up to limit jobs that are due for polling and postpones them by lease
+func (r *MemoryRepository) ClaimAccrualJobs(_ context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+
+	var due []string
+	for number, job := range r.jobs {
+		if !job.nextAttemptAt.After(now) && r.orderIndex[number].Status.IsPollable() {
+			due = append(due, number)
+		}
+	}
+
+	slices.SortFunc(due, func(a, b string) int {
+		return r.jobs[a].nextAttemptAt.Compare(r.jobs[b].nextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	res := make([]models.AccrualJob, 0, len(due))
+	for _, number := range due {
+		job := r.jobs[number]
+		job.nextAttemptAt = now.Add(lease)
+		order := r.orderIndex[number]
+		res = append(res, models.AccrualJob{Order: *order, Attempts: job.attempts, Age: now.Sub(order.UploadedAt)})
+	}
+
+	return res, nil
+}
+
+// RescheduleAccrualJob records a failed poll of the order and postpones the next one by delay
+func (r *MemoryRepository) RescheduleAccrualJob(_ context.Context, number string, delay time.Duration, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[number]; ok {
+		job.attempts++
+		job.lastError = reason
+		job.nextAttemptAt = r.now().Add(delay)
+	}
+
+	return nil
+}
+
+// CreateWithdrawal stores a withdrawal and debits the user's balance. Each order can be
+// paid with points only once.
+func (r *MemoryRepository) CreateWithdrawal(_ context.Context, w models.Withdrawal) error {
+	if w.Sum <= 0 {
+		return fmt.Errorf("%w: withdrawal of %v", ErrInvalidPosting, w.Sum)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[w.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, w.Username)
+	}
+
+	for _, existing := range r.withdrawals {
+		if existing.Order == w.Order {
+			return ErrWithdrawalExists
+		}
+	}
+
+	if r.balances[w.Username] < w.Sum {
+		return ErrInsufficientFunds
+	}
+
+	r.balances[w.Username] -= w.Sum
+	w.ProcessedAt = r.timestamp()
+	r.withdrawals = append(r.withdrawals, w)
+
+	return nil
+}
+
+// GetWithdrawalsByUsername returns the withdrawals of the user, newest first
+func (r *MemoryRepository) GetWithdrawalsByUsername(_ context.Context, username string) ([]models.Withdrawal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]models.Withdrawal, 0)
+	for i := len(r.withdrawals) - 1; i >= 0; i-- {
+		if r.withdrawals[i].Username == username {
+			res = append(res, r.withdrawals[i])
+		}
+	}
+
+	return res, nil
+}
+
+// GetUserBalanceAndWithdrawals returns the balance of the user and the sum of all withdrawals
+func (r *MemoryRepository) GetUserBalanceAndWithdrawals(_ context.Context, username string) (models.Amount, models.Amount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var withdrawn models.Amount
+	for _, w := range r.withdrawals {
+		if w.Username == username {
+			withdrawn += w.Sum
+		}
+	}
+
+	return r.balances[username], withdrawn, nil
+}
+
+// ReserveIdempotencyKey stores a pending request for the key, leased for lease, and returns
+// the token of the reservation. If the key is already taken the stored request hash and
+// response are returned with an empty token instead. Keys older than ttl and pending keys
+// whose lease has run out are taken over.
+func (r *MemoryRepository) ReserveIdempotencyKey(_ context.Context, username, key, requestHash string, ttl, lease time.Duration) (models.IdempotentResponse, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := idempotencyKeyID{username: username, key: key}
+	now := r.now()
+
+	if stored, ok := r.idempotencyKeys[id]; ok {
+		expired := stored.createdAt.Before(now.Add(-ttl))
+		abandoned := !stored.response.Completed() && stored.lockedUntil.Before(now)
+		if !expired && !abandoned {
+			return stored.response, "", nil
+		}
+	}
+
+	token, err := newReservationToken()
+	if err != nil {
+		return models.IdempotentResponse{}, "", err
+	}
+
+	r.idempotencyKeys[id] = &memoryIdempotencyKey{
+		response:    models.IdempotentResponse{RequestHash: requestHash},
+		createdAt:   now,
+		lockedUntil: now.Add(lease),
+		token:       token,
+	}
+	return models.IdempotentResponse{}, token, nil
+}
+
+// CompleteIdempotencyKey records the response to the request holding the reservation
+// token. Once the key has been taken over by another request after the lease ran out,
+// the late response is dropped.
+func (r *MemoryRepository) CompleteIdempotencyKey(_ context.Context, username, key, token string, res models.IdempotentResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.idempotencyKeys[idempotencyKeyID{username: username, key: key}]; ok && stored.token == token && !stored.response.Completed() {
+		stored.response.StatusCode = res.StatusCode
+		stored.response.ContentType = res.ContentType
+		stored.response.Body = res.Body
+		stored.lockedUntil = time.Time{}
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey removes a key whose request failed, so that it can be retried. A key
+// taken over by another request is left alone.
+func (r *MemoryRepository) ReleaseIdempotencyKey(_ context.Context, username, key, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := idempotencyKeyID{username: username, key: key}
+	if stored, ok := r.idempotencyKeys[id]; ok && stored.token == token && !stored.response.Completed() {
+		delete(r.idempotencyKeys, id)
+	}
+
+	return nil
+}
+
+// CreateSession stores a new session with its first refresh token
+func (r *MemoryRepository) CreateSession(_ context.Context, s models.Session, refreshHash string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[s.Username]; !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, s.Username)
+	}
+
+	r.sessions[s.ID] = &memorySession{username: s.Username}
+	r.refreshTokens[refreshHash] = &memoryRefreshToken{sessionID: s.ID, expiresAt: r.now().Add(ttl)}
+
+	return nil
+}
+
+// RotateRefreshToken replaces a refresh token with a new one of the same session. Reusing
+// a replaced token revokes the session, see Repository.RotateRefreshToken.
+func (r *MemoryRepository) RotateRefreshToken(_ context.Context, oldHash, newHash string, ttl time.Duration) (models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[oldHash]
+	if !ok {
+		return models.Session{}, session.ErrInvalidRefreshToken
+	}
+
+	stored := r.sessions[token.sessionID]
+	if stored.revoked {
+		return models.Session{}, session.ErrInvalidRefreshToken
+	}
+
+	s := models.Session{ID: token.sessionID, Username: stored.username}
+
+	if token.used {
+		stored.revoked = true
+		return s, session.ErrRefreshTokenReused
+	}
+
+	now := r.now()
+	if token.expiresAt.Before(now) {
+		return models.Session{}, session.ErrInvalidRefreshToken
+	}
+
+	token.used = true
+	r.refreshTokens[newHash] = &memoryRefreshToken{sessionID: s.ID, expiresAt: now.Add(ttl)}
+
+	return s, nil
+}
+
+// RevokeSession stops accepting the access and refresh tokens of the session
+func (r *MemoryRepository) RevokeSession(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sessions[id]; ok {
+		s.revoked = true
+	}
+
+	return nil
+}
+
+// SessionActive reports whether the session exists and hasn't been revoked
+func (r *MemoryRepository) SessionActive(_ context.Context, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	return ok && !s.revoked, nil
+}
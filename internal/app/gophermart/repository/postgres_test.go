@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
+	"github.com/atinyakov/go-musthave-diploma/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupPostgres connects to the database from TEST_DATABASE_URI. Tests that
+// need a real PostgreSQL are skipped when it is not set.
+func setupPostgres(t *testing.T) *Repository {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	conn := db.InitDB(dsn)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	migrator, err := db.NewMigrator(conn)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	return New(conn)
+}
+
+func TestCreateWithdrawal_ParallelNeverOverdraws(t *testing.T) {
+	repo := setupPostgres(t)
+	ctx := context.Background()
+
+	username := fmt.Sprintf("withdraw-race-%d", time.Now().UnixNano())
+	require.NoError(t, repo.CreateUser(username, "hash"))
+
+	_, _, err := repo.CreateOrder(ctx, models.Order{Number: username + "-accrual", Username: username, Status: models.StatusNew})
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateOrders(ctx, []models.Order{{Number: username + "-accrual", Status: models.StatusProcessed, Accrual: models.NewAmount(100)}}))
+
+	const attempts = 20
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		succeeded    int
+		insufficient int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			err := repo.CreateWithdrawal(ctx, models.Withdrawal{Username: username, Order: fmt.Sprintf("%s-%d", username, i), Sum: models.NewAmount(30)})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrInsufficientFunds):
+				insufficient++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 3, succeeded)
+	assert.Equal(t, attempts-3, insufficient)
+
+	balance, withdrawn, err := repo.GetUserBalanceAndWithdrawals(ctx, username)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, balance, models.Amount(0))
+	assert.Equal(t, models.NewAmount(90), withdrawn)
+}
+
+func TestRotateRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	repo := setupPostgres(t)
+	ctx := context.Background()
+
+	username := fmt.Sprintf("refresh-reuse-%d", time.Now().UnixNano())
+	require.NoError(t, repo.CreateUser(username, "hash"))
+
+	s := models.Session{ID: username, Username: username}
+	require.NoError(t, repo.CreateSession(ctx, s, username+"-1", time.Hour))
+
+	got, err := repo.RotateRefreshToken(ctx, username+"-1", username+"-2", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	// The first token is presented again, e.g. by whoever stole it
+	_, err = repo.RotateRefreshToken(ctx, username+"-1", username+"-3", time.Hour)
+	assert.ErrorIs(t, err, session.ErrRefreshTokenReused)
+
+	// The token issued by the legitimate refresh is revoked along with the session
+	_, err = repo.RotateRefreshToken(ctx, username+"-2", username+"-4", time.Hour)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+	active, err := repo.SessionActive(ctx, s.ID)
+	require.NoError(t, err)
+	assert.False(t, active)
+}
@@ -2,14 +2,19 @@ package repository
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
-	"math"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/ledger"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
 )
 
 type Repository struct {
@@ -18,6 +23,14 @@ type Repository struct {
 
 var ErrUserExists = errors.New("user already exists")
 var ErrUserNotFound = errors.New("user not found")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrWithdrawalExists = errors.New("order is already paid with points")
+var ErrInsufficientFunds = ledger.ErrInsufficientFunds
+var ErrInvalidPosting = ledger.ErrInvalidPosting
+
+// processingPollDelay is how long an order the accrual system is still processing waits
+// before it is polled again, so that pending orders don't use up the shared rate limit
+const processingPollDelay = 5 * time.Second
 
 func New(db *sql.DB) *Repository {
 	return &Repository{db: db}
@@ -52,6 +65,25 @@ func (r *Repository) GetPasswordHashByUsername(username string) (string, error)
 	return hashedPassword, nil
 }
 
+// UpdatePasswordHash replaces the stored password hash of the user
+func (r *Repository) UpdatePasswordHash(username, passwordHash string) error {
+	res, err := r.db.Exec("UPDATE users SET password_hash = $2 WHERE username = $1", username, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
 func (r *Repository) CreateOrder(ctx context.Context, newOrder models.Order) (*models.Order, bool, error) {
 	// Check if the order already exists
 	var exists bool
@@ -71,12 +103,33 @@ func (r *Repository) CreateOrder(ctx context.Context, newOrder models.Order) (*m
 		return &order, true, nil
 	}
 
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
 	// If the order doesn't exist, insert a new order into the `orders` table
-	_, err = r.db.ExecContext(ctx, "INSERT INTO orders (number, username, status, accrual) VALUES ($1, $2, $3, $4)", newOrder.Number, newOrder.Username, newOrder.Status, newOrder.Accrual)
+	_, err = tx.ExecContext(ctx, "INSERT INTO orders (number, username, status, accrual) VALUES ($1, $2, $3, $4)", newOrder.Number, newOrder.Username, newOrder.Status, newOrder.Accrual)
 	if err != nil {
 		return nil, false, err
 	}
 
+	// New orders are queued for polling in the accrual system
+	if newOrder.Status == models.StatusNew {
+		_, err = tx.ExecContext(ctx, "INSERT INTO accrual_jobs (order_number) VALUES ($1)", newOrder.Number)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
 	// Return the newly created order
 	createdOrder := &models.Order{
 		Username:   newOrder.Username,
@@ -88,41 +141,50 @@ func (r *Repository) CreateOrder(ctx context.Context, newOrder models.Order) (*m
 	return createdOrder, false, nil
 }
 
-func (r *Repository) GetOrdersByStatus(ctx context.Context) ([]models.Order, error) {
-	rows, err := r.db.QueryContext(ctx, "SELECT number, username, status, accrual, uploaded_at FROM orders WHERE status <> $1", models.StatusProcessed)
-	if err != nil {
-		slog.Error("GetOrdersByStatus error=%s", slog.String("error", err.Error()))
-		return []models.Order{}, nil
+// GetOrders returns the orders selected by q
+func (r *Repository) GetOrders(ctx context.Context, q models.OrderQuery) ([]models.Order, error) {
+	query := "SELECT number, username, status, accrual, uploaded_at FROM orders WHERE username = $1"
+	args := []any{q.Username}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
 	}
-	defer rows.Close()
 
-	res := make([]models.Order, 0)
-
-	for rows.Next() {
-		var order models.Order
-
-		err := rows.Scan(&order.Number, &order.Username, &order.Status, &order.Accrual, &order.UploadedAt)
-		if err != nil {
-			slog.Error("GetOrdersByStatus error=%s", slog.String("error", err.Error()))
-			return nil, err
+	if len(q.Statuses) > 0 {
+		placeholders := make([]string, len(q.Statuses))
+		for i, status := range q.Statuses {
+			placeholders[i] = arg(string(status))
 		}
+		query += " AND status IN (" + strings.Join(placeholders, ", ") + ")"
+	}
 
-		res = append(res, order)
+	// uploaded_at has no time zone, the bounds are converted to the zone of the database
+	if q.UploadedFrom != nil {
+		query += " AND uploaded_at >= " + arg(*q.UploadedFrom) + "::timestamptz"
+	}
+	if q.UploadedTo != nil {
+		query += " AND uploaded_at < " + arg(*q.UploadedTo) + "::timestamptz"
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	direction, compare := "DESC", "<"
+	if q.Ascending {
+		direction, compare = "ASC", ">"
 	}
 
-	return res, nil
+	if q.After != nil {
+		query += " AND (uploaded_at, number) " + compare + " (" + arg(q.After.UploadedAt) + ", " + arg(q.After.Number) + ")"
+	}
 
-}
+	query += " ORDER BY uploaded_at " + direction + ", number " + direction
 
-func (r *Repository) GetOrdersByUsername(ctx context.Context, username string) ([]models.Order, error) {
-	rows, err := r.db.QueryContext(ctx, "SELECT number, username, status, accrual, uploaded_at FROM orders WHERE username = $1", username)
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		slog.Error("GetOrdersByUsername error=%s", slog.String("error", err.Error()))
-		return []models.Order{}, nil
+		slog.Error("GetOrders error", slog.String("error", err.Error()))
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -133,7 +195,7 @@ func (r *Repository) GetOrdersByUsername(ctx context.Context, username string) (
 
 		err := rows.Scan(&order.Number, &order.Username, &order.Status, &order.Accrual, &order.UploadedAt)
 		if err != nil {
-			slog.Error("GetOrdersByUsername error=%s", slog.String("error", err.Error()))
+			slog.Error("GetOrders error", slog.String("error", err.Error()))
 			return nil, err
 		}
 
@@ -147,8 +209,13 @@ func (r *Repository) GetOrdersByUsername(ctx context.Context, username string) (
 	return res, nil
 }
 
+// UpdateOrders applies accrual results. Every update is checked against the order lifecycle:
+// orders with an illegal transition or unknown number are skipped while the others are still
+// stored, and the returned error wraps models.ErrIllegalTransition or ErrOrderNotFound.
+// Repeating the current final status is a no-op. The accrual of a processed order is
+// credited to the user's ledger account in the same transaction.
 func (r *Repository) UpdateOrders(ctx context.Context, os []models.Order) error {
-	tx, err := r.db.Begin()
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
@@ -167,47 +234,199 @@ func (r *Repository) UpdateOrders(ctx context.Context, os []models.Order) error
 	}
 
 	defer stmt.Close()
+
+	var rejected []error
 	for _, o := range os {
+		var current models.OrderStatus
+		var username string
+		err = tx.QueryRowContext(ctx, "SELECT status, username FROM orders WHERE number = $1 FOR UPDATE", o.Number).Scan(&current, &username)
+		if errors.Is(err, sql.ErrNoRows) {
+			rejected = append(rejected, fmt.Errorf("%w: %s", ErrOrderNotFound, o.Number))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if current == o.Status && current.IsFinal() {
+			continue
+		}
+
+		if !current.CanTransitionTo(o.Status) {
+			rejected = append(rejected, fmt.Errorf("%w: order %s from %s to %s", models.ErrIllegalTransition, o.Number, current, o.Status))
+			continue
+		}
 
 		_, err = stmt.ExecContext(ctx, o.Status, o.Accrual, o.Number)
 
 		if err != nil {
 			return err
 		}
+
+		if o.Status == models.StatusProcessed && o.Accrual > 0 {
+			_, err = ledger.Post(ctx, tx, ledger.Posting{
+				Kind:      ledger.KindAccrual,
+				Reference: o.Number,
+				From:      ledger.AccountAccruals,
+				To:        ledger.UserAccount(username),
+				Amount:    o.Accrual,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Orders with a final status are not polled anymore, others are polled again after processingPollDelay
+		if o.Status.IsFinal() {
+			_, err = tx.ExecContext(ctx, "DELETE FROM accrual_jobs WHERE order_number = $1", o.Number)
+		} else {
+			_, err = tx.ExecContext(ctx, "UPDATE accrual_jobs SET attempts = 0, last_error = NULL, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2) WHERE order_number = $1",
+				o.Number, processingPollDelay.Seconds())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return errors.Join(rejected...)
+}
+
+// ClaimAccrualJobs returns up to limit jobs that are due for polling and postpones them by lease,
+// so that other workers skip them. Rows locked by another replica are skipped as well.
+func (r *Repository) ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	query := `
+	UPDATE accrual_jobs j
+	SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	FROM orders o
+	WHERE o.number = j.order_number AND j.order_number IN (
+		SELECT aj.order_number FROM accrual_jobs aj
+		JOIN orders ao ON ao.number = aj.order_number
+		WHERE aj.next_attempt_at <= CURRENT_TIMESTAMP AND ao.status IN ('NEW', 'PROCESSING')
+		ORDER BY aj.next_attempt_at
+		LIMIT $1
+		FOR UPDATE OF aj SKIP LOCKED
+	)
+	RETURNING o.number, o.username, o.status, o.accrual, o.uploaded_at, j.attempts, EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - o.uploaded_at);`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		slog.Error("ClaimAccrualJobs error=%s", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]models.AccrualJob, 0)
+
+	for rows.Next() {
+		var job models.AccrualJob
+		var age float64
+
+		// uploaded_at has no time zone, so the age is computed by the database rather than
+		// against the clock of the server
+		err := rows.Scan(&job.Order.Number, &job.Order.Username, &job.Order.Status, &job.Order.Accrual, &job.Order.UploadedAt, &job.Attempts, &age)
+		if err != nil {
+			slog.Error("ClaimAccrualJobs error=%s", slog.String("error", err.Error()))
+			return nil, err
+		}
+		job.Age = time.Duration(age * float64(time.Second))
+
+		res = append(res, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RescheduleAccrualJob records a failed poll of the order and postpones the next one by delay
+func (r *Repository) RescheduleAccrualJob(ctx context.Context, number string, delay time.Duration, reason string) error {
+	query := `
+	UPDATE accrual_jobs
+	SET attempts = attempts + 1, last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3)
+	WHERE order_number = $1;`
+
+	_, err := r.db.ExecContext(ctx, query, number, reason, delay.Seconds())
+	return err
+}
+
+// CreateWithdrawal stores a withdrawal and debits the user's ledger account. The account
+// is locked until the transaction ends, so parallel withdrawals of one user are serialized
+// and the balance can't go negative. Each order can be paid with points only once.
+func (r *Repository) CreateWithdrawal(ctx context.Context, w models.Withdrawal) error {
+	// Checked before the insert, so that the error doesn't depend on the CHECK constraint
+	if w.Sum <= 0 {
+		return fmt.Errorf("%w: withdrawal of %v", ErrInvalidPosting, w.Sum)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO withdrawals (order_number, username, sum) VALUES ($1, $2, $3) ON CONFLICT (order_number) DO NOTHING", w.Order, w.Username, w.Sum)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if inserted == 0 {
+		return ErrWithdrawalExists
+	}
+
+	_, err = ledger.Post(ctx, tx, ledger.Posting{
+		Kind:      ledger.KindWithdrawal,
+		Reference: w.Order,
+		From:      ledger.UserAccount(w.Username),
+		To:        ledger.AccountWithdrawals,
+		Amount:    w.Sum,
+	})
+	if err != nil {
+		return err
 	}
 
 	return tx.Commit()
 }
 
-func (r *Repository) GetWithdrawalsByUsername(ctx context.Context, username string) ([]models.Order, error) {
+func (r *Repository) GetWithdrawalsByUsername(ctx context.Context, username string) ([]models.Withdrawal, error) {
 	query := `
-	SELECT number, username, status, accrual, uploaded_at 
-	FROM orders 
-	WHERE username = $1 AND accrual < 0 
-	ORDER BY uploaded_at DESC;`
+	SELECT order_number, username, sum, processed_at
+	FROM withdrawals
+	WHERE username = $1
+	ORDER BY processed_at DESC;`
 
 	rows, err := r.db.QueryContext(ctx, query, username)
 	if err != nil {
 		slog.Error("GetWithdrawalsByUsername error=%s", slog.String("error", err.Error()))
-		return []models.Order{}, nil
+		return []models.Withdrawal{}, nil
 	}
 	defer rows.Close()
 
-	res := make([]models.Order, 0)
+	res := make([]models.Withdrawal, 0)
 
 	for rows.Next() {
-		var order models.Order
+		var w models.Withdrawal
 
-		err := rows.Scan(&order.Number, &order.Username, &order.Status, &order.Accrual, &order.UploadedAt)
+		err := rows.Scan(&w.Order, &w.Username, &w.Sum, &w.ProcessedAt)
 		if err != nil {
 			slog.Error("GetWithdrawalsByUsername error=%s", slog.String("error", err.Error()))
 			return nil, err
 		}
-		// Convert negative accrual to positive
-		order.Accrual = math.Abs(order.Accrual) // Assuming Accrual is a float
-		// If Accrual is an int, use: order.Accrual = -order.Accrual
 
-		res = append(res, order)
+		res = append(res, w)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -217,21 +436,201 @@ func (r *Repository) GetWithdrawalsByUsername(ctx context.Context, username stri
 	return res, nil
 }
 
-func (r *Repository) GetUserBalanceAndWithdrawals(ctx context.Context, username string) (float64, float64, error) {
+// GetUserBalanceAndWithdrawals returns the balance snapshot of the user's ledger account and the sum of all withdrawals
+func (r *Repository) GetUserBalanceAndWithdrawals(ctx context.Context, username string) (models.Amount, models.Amount, error) {
 	query := `
-		SELECT 
-			COALESCE(SUM(accrual), 0), 
-			COALESCE(SUM(CASE WHEN accrual < 0 THEN accrual ELSE 0 END), 0) 
-		FROM orders 
-		WHERE username = $1;`
+		SELECT
+			COALESCE((SELECT balance FROM ledger_accounts WHERE name = $1), 0),
+			COALESCE((SELECT SUM(sum) FROM withdrawals WHERE username = $2), 0);`
 
-	var balance, withdrawals float64
-	err := r.db.QueryRowContext(ctx, query, username).Scan(&balance, &withdrawals)
+	var balance, withdrawals models.Amount
+	err := r.db.QueryRowContext(ctx, query, ledger.UserAccount(username), username).Scan(&balance, &withdrawals)
 	if err != nil {
 		slog.Error("GetUserBalanceAndWithdrawals error: %s\n", slog.String("error", err.Error()))
 		return 0, 0, err
 	}
 
-	// Convert withdrawals to positive since they are stored as negative values
-	return balance, -withdrawals, nil
+	return balance, withdrawals, nil
+}
+
+// ReserveIdempotencyKey stores a pending request for the key, leased for lease, and returns
+// the token of the reservation. If the key is already taken the stored request hash and
+// response are returned with an empty token instead. Keys older than ttl and pending keys
+// whose lease has run out are taken over.
+func (r *Repository) ReserveIdempotencyKey(ctx context.Context, username, key, requestHash string, ttl, lease time.Duration) (models.IdempotentResponse, string, error) {
+	reserve := `
+	INSERT INTO idempotency_keys (username, key, request_hash, locked_until, reservation_token) VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $5), $6)
+	ON CONFLICT (username, key) DO UPDATE
+	SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL,
+		created_at = CURRENT_TIMESTAMP, locked_until = EXCLUDED.locked_until, reservation_token = EXCLUDED.reservation_token
+	WHERE idempotency_keys.created_at < CURRENT_TIMESTAMP - make_interval(secs => $4)
+		OR (idempotency_keys.status_code IS NULL AND COALESCE(idempotency_keys.locked_until, idempotency_keys.created_at) < CURRENT_TIMESTAMP);`
+
+	token, err := newReservationToken()
+	if err != nil {
+		return models.IdempotentResponse{}, "", err
+	}
+
+	// The key may be released between the two statements, then it is reserved again
+	for attempt := 0; attempt < 3; attempt++ {
+		res, err := r.db.ExecContext(ctx, reserve, username, key, requestHash, ttl.Seconds(), lease.Seconds(), token)
+		if err != nil {
+			return models.IdempotentResponse{}, "", err
+		}
+
+		reserved, err := res.RowsAffected()
+		if err != nil {
+			return models.IdempotentResponse{}, "", err
+		}
+
+		if reserved == 1 {
+			return models.IdempotentResponse{}, token, nil
+		}
+
+		var stored models.IdempotentResponse
+		err = r.db.QueryRowContext(ctx, "SELECT request_hash, COALESCE(status_code, 0), COALESCE(content_type, ''), response_body FROM idempotency_keys WHERE username = $1 AND key = $2", username, key).
+			Scan(&stored.RequestHash, &stored.StatusCode, &stored.ContentType, &stored.Body)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return models.IdempotentResponse{}, "", err
+		}
+
+		return stored, "", nil
+	}
+
+	return models.IdempotentResponse{}, "", fmt.Errorf("idempotency key %q is being released concurrently", key)
+}
+
+// CompleteIdempotencyKey records the response to the request holding the reservation
+// token. Once the key has been taken over by another request after the lease ran out,
+// the late response is dropped.
+func (r *Repository) CompleteIdempotencyKey(ctx context.Context, username, key, token string, res models.IdempotentResponse) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE idempotency_keys SET status_code = $4, content_type = $5, response_body = $6, locked_until = NULL WHERE username = $1 AND key = $2 AND reservation_token = $3 AND status_code IS NULL",
+		username, key, token, res.StatusCode, res.ContentType, res.Body)
+	return err
+}
+
+// ReleaseIdempotencyKey removes a key whose request failed, so that it can be retried. A key
+// taken over by another request is left alone.
+func (r *Repository) ReleaseIdempotencyKey(ctx context.Context, username, key, token string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE username = $1 AND key = $2 AND reservation_token = $3 AND status_code IS NULL", username, key, token)
+	return err
+}
+
+// newReservationToken tells the request holding an idempotency key from a request that
+// took the key over after its lease ran out
+func newReservationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate reservation token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateSession stores a new session with its first refresh token
+func (r *Repository) CreateSession(ctx context.Context, s models.Session, refreshHash string, ttl time.Duration) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO sessions (id, username) VALUES ($1, $2)", s.ID, s.Username)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3))",
+		refreshHash, s.ID, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RotateRefreshToken replaces a refresh token with a new one of the same session. Reusing
+// a replaced token revokes the session, because either the client or an attacker holds a
+// stolen copy and there is no telling which.
+func (r *Repository) RotateRefreshToken(ctx context.Context, oldHash, newHash string, ttl time.Duration) (models.Session, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Session{}, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	query := `
+	SELECT s.id, s.username, rt.used_at IS NOT NULL, rt.expires_at < CURRENT_TIMESTAMP, s.revoked_at IS NOT NULL
+	FROM refresh_tokens rt
+	JOIN sessions s ON s.id = rt.session_id
+	WHERE rt.token_hash = $1
+	FOR UPDATE;`
+
+	var s models.Session
+	var used, expired, revoked bool
+	err = tx.QueryRowContext(ctx, query, oldHash).Scan(&s.ID, &s.Username, &used, &expired, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, session.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	if revoked {
+		return models.Session{}, session.ErrInvalidRefreshToken
+	}
+
+	if used {
+		_, err = tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1", s.ID)
+		if err != nil {
+			return models.Session{}, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return models.Session{}, err
+		}
+		return s, session.ErrRefreshTokenReused
+	}
+
+	if expired {
+		return models.Session{}, session.ErrInvalidRefreshToken
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1", oldHash)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3))",
+		newHash, s.ID, ttl.Seconds())
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Session{}, err
+	}
+
+	return s, nil
+}
+
+// RevokeSession stops accepting the access and refresh tokens of the session
+func (r *Repository) RevokeSession(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL", id)
+	return err
+}
+
+// SessionActive reports whether the session exists and hasn't been revoked
+func (r *Repository) SessionActive(ctx context.Context, id string) (bool, error) {
+	var active bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL)", id).Scan(&active)
+	return active, err
 }
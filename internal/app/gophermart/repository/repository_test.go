@@ -3,12 +3,12 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"math"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -86,16 +86,21 @@ func TestCreateOrder_NewOrder(t *testing.T) {
 		WithArgs("12345").
 		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO orders").
-		WithArgs("12345", "testuser", "NEW", 0.0).
+		WithArgs("12345", "testuser", "NEW", models.Amount(0)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO accrual_jobs \\(order_number\\) VALUES \\(\\$1\\)").
+		WithArgs("12345").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	ctx := context.Background()
 	newOrder := models.Order{
 		Number:   "12345",
 		Username: "testuser",
 		Status:   "NEW",
-		Accrual:  0.0,
+		Accrual:  0,
 	}
 
 	order, exists, err := repo.CreateOrder(ctx, newOrder)
@@ -118,7 +123,7 @@ func TestCreateOrder_AlreadyExists(t *testing.T) {
 	mock.ExpectQuery("SELECT number, username, status, accrual, uploaded_at FROM orders WHERE number = \\$1").
 		WithArgs("12345").
 		WillReturnRows(sqlmock.NewRows([]string{"number", "username", "status", "accrual", "uploaded_at"}).
-			AddRow("12345", "testuser", "PROCESSED", 100.0, time.Now()))
+			AddRow("12345", "testuser", "PROCESSED", "100.00", time.Now()))
 
 	ctx := context.Background()
 	newOrder := models.Order{Number: "12345"}
@@ -132,18 +137,18 @@ func TestCreateOrder_AlreadyExists(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestGetOrdersByUsername_Success(t *testing.T) {
+func TestGetOrders_Success(t *testing.T) {
 	db, mock, repo := setupMockDB(t)
 	defer db.Close()
 
 	mock.ExpectQuery("SELECT number, username, status, accrual, uploaded_at FROM orders WHERE username = \\$1").
 		WithArgs("testuser").
 		WillReturnRows(sqlmock.NewRows([]string{"number", "username", "status", "accrual", "uploaded_at"}).
-			AddRow("12345", "testuser", "NEW", 0.0, time.Now()).
-			AddRow("67890", "testuser", "PROCESSED", 50.0, time.Now()))
+			AddRow("12345", "testuser", "NEW", "0.00", time.Now()).
+			AddRow("67890", "testuser", "PROCESSED", "50.00", time.Now()))
 
 	ctx := context.Background()
-	orders, err := repo.GetOrdersByUsername(ctx, "testuser")
+	orders, err := repo.GetOrders(ctx, models.OrderQuery{Username: "testuser"})
 
 	assert.NoError(t, err)
 	assert.Len(t, orders, 2)
@@ -152,22 +157,75 @@ func TestGetOrdersByUsername_Success(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestGetOrders_Query(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	after := models.OrderCursor{UploadedAt: from.Add(time.Hour), Number: "12345"}
+
+	mock.ExpectQuery("SELECT number, username, status, accrual, uploaded_at FROM orders WHERE username = \\$1 "+
+		"AND status IN \\(\\$2, \\$3\\) AND uploaded_at >= \\$4::timestamptz AND uploaded_at < \\$5::timestamptz "+
+		"AND \\(uploaded_at, number\\) > \\(\\$6, \\$7\\) ORDER BY uploaded_at ASC, number ASC LIMIT \\$8").
+		WithArgs("testuser", "NEW", "PROCESSED", from, to, after.UploadedAt, "12345", 11).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "username", "status", "accrual", "uploaded_at"}).
+			AddRow("67890", "testuser", "NEW", "0.00", from.Add(2*time.Hour)))
+
+	orders, err := repo.GetOrders(context.Background(), models.OrderQuery{
+		Username:     "testuser",
+		Statuses:     []models.OrderStatus{models.StatusNew, models.StatusProcessed},
+		UploadedFrom: &from,
+		UploadedTo:   &to,
+		Ascending:    true,
+		After:        &after,
+		Limit:        11,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, orders, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrders_NewestFirstByDefault(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT number, username, status, accrual, uploaded_at FROM orders WHERE username = \\$1 ORDER BY uploaded_at DESC, number DESC$").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows([]string{"number", "username", "status", "accrual", "uploaded_at"}))
+
+	_, err := repo.GetOrders(context.Background(), models.OrderQuery{Username: "testuser"})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestUpdateOrders_Success(t *testing.T) {
 	db, mock, repo := setupMockDB(t)
 	defer db.Close()
 
 	mock.ExpectBegin()
 
-	mock.ExpectPrepare("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3").
-		ExpectExec().
-		WithArgs("PROCESSED", 100.0, "12345").
+	prep := mock.ExpectPrepare("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3")
+	mock.ExpectQuery("SELECT status, username FROM orders WHERE number = \\$1 FOR UPDATE").
+		WithArgs("12345").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "username"}).AddRow("PROCESSING", "testuser"))
+	prep.ExpectExec().
+		WithArgs("PROCESSED", models.NewAmount(100), "12345").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLockBalance(mock, "user:testuser", 20)
+	expectPosting(mock, 1, "system:accruals", "user:testuser", "ACCRUAL", "12345", models.NewAmount(100))
+	mock.ExpectExec("UPDATE ledger_accounts SET balance = balance \\+ \\$2").
+		WithArgs("user:testuser", models.NewAmount(100)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM accrual_jobs WHERE order_number = \\$1").
+		WithArgs("12345").
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	mock.ExpectCommit()
 
 	ctx := context.Background()
 	orders := []models.Order{
-		{Number: "12345", Status: "PROCESSED", Accrual: 100.0},
+		{Number: "12345", Status: "PROCESSED", Accrual: models.NewAmount(100)},
 	}
 
 	err := repo.UpdateOrders(ctx, orders)
@@ -175,65 +233,214 @@ func TestUpdateOrders_Success(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestGetWithdrawalsByUsername(t *testing.T) {
+func TestUpdateOrders_NotFinalKeepsJob(t *testing.T) {
 	db, mock, repo := setupMockDB(t)
 	defer db.Close()
 
-	username := "testuser"
-	expectedOrders := []models.Order{
-		{Number: "order1", Username: username, Status: "processed", Accrual: -50, UploadedAt: time.Now()},
-		{Number: "order2", Username: username, Status: "processed", Accrual: -30, UploadedAt: time.Now().Add(-time.Hour)},
-	}
+	mock.ExpectBegin()
 
-	rows := sqlmock.NewRows([]string{"number", "username", "status", "accrual", "uploaded_at"}).
-		AddRow(expectedOrders[0].Number, expectedOrders[0].Username, expectedOrders[0].Status, expectedOrders[0].Accrual, expectedOrders[0].UploadedAt).
-		AddRow(expectedOrders[1].Number, expectedOrders[1].Username, expectedOrders[1].Status, expectedOrders[1].Accrual, expectedOrders[1].UploadedAt)
+	prep := mock.ExpectPrepare("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3")
+	mock.ExpectQuery("SELECT status, username FROM orders WHERE number = \\$1 FOR UPDATE").
+		WithArgs("12345").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "username"}).AddRow("NEW", "testuser"))
+	prep.ExpectExec().
+		WithArgs("PROCESSING", models.Amount(0), "12345").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accrual_jobs SET attempts = 0, last_error = NULL, next_attempt_at = CURRENT_TIMESTAMP \\+ make_interval\\(secs => \\$2\\) WHERE order_number = \\$1").
+		WithArgs("12345", processingPollDelay.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery("SELECT number, username, status, accrual, uploaded_at FROM orders WHERE username = \\$1 AND accrual < 0").
-		WithArgs(username).
-		WillReturnRows(rows)
+	mock.ExpectCommit()
+
+	err := repo.UpdateOrders(context.Background(), []models.Order{{Number: "12345", Status: "PROCESSING"}})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOrders_RejectsIllegalTransition(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+
+	prep := mock.ExpectPrepare("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3")
+	mock.ExpectQuery("SELECT status, username FROM orders WHERE number = \\$1 FOR UPDATE").
+		WithArgs("111").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "username"}).AddRow("PROCESSED", "testuser"))
+	mock.ExpectQuery("SELECT status, username FROM orders WHERE number = \\$1 FOR UPDATE").
+		WithArgs("222").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT status, username FROM orders WHERE number = \\$1 FOR UPDATE").
+		WithArgs("333").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "username"}).AddRow("PROCESSING", "testuser"))
+	prep.ExpectExec().
+		WithArgs("INVALID", models.Amount(0), "333").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM accrual_jobs WHERE order_number = \\$1").
+		WithArgs("333").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	err := repo.UpdateOrders(context.Background(), []models.Order{
+		{Number: "111", Status: models.StatusNew},
+		{Number: "222", Status: models.StatusProcessed},
+		{Number: "333", Status: models.StatusInvalid},
+	})
+	assert.ErrorIs(t, err, models.ErrIllegalTransition)
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-	orders, err := repo.GetWithdrawalsByUsername(context.Background(), username)
+func TestUpdateOrders_RepeatedFinalStatusIsNoop(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3")
+	mock.ExpectQuery("SELECT status, username FROM orders WHERE number = \\$1 FOR UPDATE").
+		WithArgs("111").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "username"}).AddRow("PROCESSED", "testuser"))
+	mock.ExpectCommit()
+
+	err := repo.UpdateOrders(context.Background(), []models.Order{{Number: "111", Status: models.StatusProcessed, Accrual: models.NewAmount(10)}})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimAccrualJobs(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	uploadedAt := time.Now()
+	mock.ExpectQuery("UPDATE accrual_jobs j SET next_attempt_at = CURRENT_TIMESTAMP \\+ make_interval\\(secs => \\$2\\) FROM orders o .* ao.status IN \\('NEW', 'PROCESSING'\\) .* FOR UPDATE OF aj SKIP LOCKED \\) "+
+		"RETURNING o.number, o.username, o.status, o.accrual, o.uploaded_at, j.attempts, EXTRACT\\(EPOCH FROM CURRENT_TIMESTAMP - o.uploaded_at\\)").
+		WithArgs(10, 30.0).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "username", "status", "accrual", "uploaded_at", "attempts", "age"}).
+			AddRow("12345", "testuser", "NEW", "0.00", uploadedAt, 2, "5400.5"))
+
+	jobs, err := repo.ClaimAccrualJobs(context.Background(), 10, 30*time.Second)
 	assert.NoError(t, err)
-	assert.Len(t, orders, len(expectedOrders))
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, "12345", jobs[0].Order.Number)
+	assert.Equal(t, models.StatusNew, jobs[0].Order.Status)
+	assert.Equal(t, uploadedAt, jobs[0].Order.UploadedAt)
+	assert.Equal(t, 2, jobs[0].Attempts)
+	assert.Equal(t, 90*time.Minute+500*time.Millisecond, jobs[0].Age)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRescheduleAccrualJob(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE accrual_jobs SET attempts = attempts \\+ 1, last_error = \\$2, next_attempt_at = CURRENT_TIMESTAMP \\+ make_interval\\(secs => \\$3\\) WHERE order_number = \\$1").
+		WithArgs("12345", "boom", 4.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := repo.RescheduleAccrualJob(context.Background(), "12345", 4*time.Second, "boom")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func expectLockBalance(mock sqlmock.Sqlmock, account string, balance float64) {
+	mock.ExpectExec("INSERT INTO ledger_accounts \\(name\\) VALUES \\(\\$1\\) ON CONFLICT \\(name\\) DO NOTHING").
+		WithArgs(account).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT balance FROM ledger_accounts WHERE name = \\$1 FOR UPDATE").
+		WithArgs(account).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(balance))
+}
+
+func expectPosting(mock sqlmock.Sqlmock, id int64, from, to, kind, reference string, amount models.Amount) {
+	mock.ExpectQuery("SELECT nextval\\('ledger_posting_seq'\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(id))
+	mock.ExpectExec("INSERT INTO ledger_entries").
+		WithArgs(id, from, -amount, kind, reference, "", to, amount).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+}
+
+func expectWithdrawalInsert(mock sqlmock.Sqlmock, inserted int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO withdrawals \\(order_number, username, sum\\) VALUES \\(\\$1, \\$2, \\$3\\) ON CONFLICT \\(order_number\\) DO NOTHING").
+		WithArgs("2377225624", "testuser", models.NewAmount(751)).
+		WillReturnResult(sqlmock.NewResult(0, inserted))
+}
+
+func TestCreateWithdrawal(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	expectWithdrawalInsert(mock, 1)
+	expectLockBalance(mock, "user:testuser", 1000)
+	expectPosting(mock, 7, "user:testuser", "system:withdrawals", "WITHDRAWAL", "2377225624", models.NewAmount(751))
+	mock.ExpectExec("UPDATE ledger_accounts SET balance = balance \\+ \\$2").
+		WithArgs("user:testuser", models.NewAmount(-751)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.CreateWithdrawal(context.Background(), models.Withdrawal{Order: "2377225624", Username: "testuser", Sum: models.NewAmount(751)})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateWithdrawal_AlreadyExists(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	expectWithdrawalInsert(mock, 0)
+	mock.ExpectRollback()
+
+	err := repo.CreateWithdrawal(context.Background(), models.Withdrawal{Order: "2377225624", Username: "testuser", Sum: models.NewAmount(751)})
+	assert.ErrorIs(t, err, ErrWithdrawalExists)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateWithdrawal_InsufficientFunds(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	expectWithdrawalInsert(mock, 1)
+	expectLockBalance(mock, "user:testuser", 750.99)
+	mock.ExpectRollback()
 
-	for i, order := range orders {
-		assert.Equal(t, expectedOrders[i].Number, order.Number)
-		assert.Equal(t, expectedOrders[i].Username, order.Username)
-		assert.Equal(t, expectedOrders[i].Status, order.Status)
-		assert.Equal(t, math.Abs(expectedOrders[i].Accrual), order.Accrual) // Ensure conversion to positive
+	err := repo.CreateWithdrawal(context.Background(), models.Withdrawal{Order: "2377225624", Username: "testuser", Sum: models.NewAmount(751)})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateWithdrawal_NonPositiveSum(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	for _, sum := range []models.Amount{0, models.NewAmount(-1000)} {
+		err := repo.CreateWithdrawal(context.Background(), models.Withdrawal{Order: "2377225624", Username: "testuser", Sum: sum})
+		assert.ErrorIs(t, err, ErrInvalidPosting)
 	}
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestGetOrdersByStatus(t *testing.T) {
+func TestGetWithdrawalsByUsername(t *testing.T) {
 	db, mock, repo := setupMockDB(t)
 	defer db.Close()
 
-	expectedOrders := []models.Order{
-		{Number: "order1", Username: "user1", Status: "pending", Accrual: 100, UploadedAt: time.Now()},
-		{Number: "order2", Username: "user2", Status: "new", Accrual: 0, UploadedAt: time.Now().Add(-time.Hour)},
+	username := "testuser"
+	expected := []models.Withdrawal{
+		{Order: "order1", Username: username, Sum: models.NewAmount(50), ProcessedAt: time.Now()},
+		{Order: "order2", Username: username, Sum: models.NewAmount(30), ProcessedAt: time.Now().Add(-time.Hour)},
 	}
 
-	rows := sqlmock.NewRows([]string{"number", "username", "status", "accrual", "uploaded_at"}).
-		AddRow(expectedOrders[0].Number, expectedOrders[0].Username, expectedOrders[0].Status, expectedOrders[0].Accrual, expectedOrders[0].UploadedAt).
-		AddRow(expectedOrders[1].Number, expectedOrders[1].Username, expectedOrders[1].Status, expectedOrders[1].Accrual, expectedOrders[1].UploadedAt)
+	rows := sqlmock.NewRows([]string{"order_number", "username", "sum", "processed_at"}).
+		AddRow(expected[0].Order, expected[0].Username, expected[0].Sum, expected[0].ProcessedAt).
+		AddRow(expected[1].Order, expected[1].Username, expected[1].Sum, expected[1].ProcessedAt)
 
-	mock.ExpectQuery("SELECT number, username, status, accrual, uploaded_at FROM orders WHERE status <> \\$1").
-		WithArgs(models.StatusProcessed).
+	mock.ExpectQuery("SELECT order_number, username, sum, processed_at FROM withdrawals WHERE username = \\$1 ORDER BY processed_at DESC").
+		WithArgs(username).
 		WillReturnRows(rows)
 
-	orders, err := repo.GetOrdersByStatus(context.Background())
+	withdrawals, err := repo.GetWithdrawalsByUsername(context.Background(), username)
 	assert.NoError(t, err)
-	assert.Len(t, orders, len(expectedOrders))
-
-	for i, order := range orders {
-		assert.Equal(t, expectedOrders[i].Number, order.Number)
-		assert.Equal(t, expectedOrders[i].Username, order.Username)
-		assert.Equal(t, expectedOrders[i].Status, order.Status)
-		assert.Equal(t, expectedOrders[i].Accrual, order.Accrual)
-		assert.Equal(t, expectedOrders[i].UploadedAt, order.UploadedAt)
-	}
+	assert.Equal(t, expected, withdrawals)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -242,17 +449,202 @@ func TestGetUserBalanceAndWithdrawals(t *testing.T) {
 	defer db.Close()
 
 	username := "testuser"
-	expectedBalance := 150.0
-	expectedWithdrawals := -50.0 // Stored as negative in DB
+	expectedBalance := models.NewAmount(150)
+	expectedWithdrawals := models.NewAmount(50)
 
-	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(accrual\\), 0\\), COALESCE\\(SUM\\(CASE WHEN accrual < 0 THEN accrual ELSE 0 END\\), 0\\) FROM orders WHERE username = \\$1").
-		WithArgs(username).
+	mock.ExpectQuery("SELECT COALESCE\\(\\(SELECT balance FROM ledger_accounts WHERE name = \\$1\\), 0\\), COALESCE\\(\\(SELECT SUM\\(sum\\) FROM withdrawals WHERE username = \\$2\\), 0\\)").
+		WithArgs("user:"+username, username).
 		WillReturnRows(sqlmock.NewRows([]string{"balance", "withdrawals"}).AddRow(expectedBalance, expectedWithdrawals))
 
 	balance, withdrawals, err := repo.GetUserBalanceAndWithdrawals(context.Background(), username)
 	assert.NoError(t, err)
 	assert.Equal(t, expectedBalance, balance)
-	assert.Equal(t, -expectedWithdrawals, withdrawals) // Convert to positive
+	assert.Equal(t, expectedWithdrawals, withdrawals)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO idempotency_keys \\(username, key, request_hash, locked_until, reservation_token\\) VALUES \\(\\$1, \\$2, \\$3, CURRENT_TIMESTAMP \\+ make_interval\\(secs => \\$5\\), \\$6\\) ON CONFLICT \\(username, key\\) DO UPDATE .* "+
+		"WHERE idempotency_keys.created_at < CURRENT_TIMESTAMP - make_interval\\(secs => \\$4\\) OR \\(idempotency_keys.status_code IS NULL AND COALESCE\\(idempotency_keys.locked_until, idempotency_keys.created_at\\) < CURRENT_TIMESTAMP\\)").
+		WithArgs("testuser", "key-1", "hash", 86400.0, 30.0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, token, err := repo.ReserveIdempotencyKey(context.Background(), "testuser", "key-1", "hash", 24*time.Hour, 30*time.Second)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReserveIdempotencyKey_ReturnsStoredResponse(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("testuser", "key-1", "hash", 86400.0, 30.0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT request_hash, COALESCE\\(status_code, 0\\), COALESCE\\(content_type, ''\\), response_body FROM idempotency_keys WHERE username = \\$1 AND key = \\$2").
+		WithArgs("testuser", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).AddRow("hash", 200, "text/plain", []byte("done")))
+
+	stored, token, err := repo.ReserveIdempotencyKey(context.Background(), "testuser", "key-1", "hash", 24*time.Hour, 30*time.Second)
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Equal(t, models.IdempotentResponse{RequestHash: "hash", StatusCode: 200, ContentType: "text/plain", Body: []byte("done")}, stored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompleteAndReleaseIdempotencyKey(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE idempotency_keys SET status_code = \\$4, content_type = \\$5, response_body = \\$6, locked_until = NULL WHERE username = \\$1 AND key = \\$2 AND reservation_token = \\$3 AND status_code IS NULL").
+		WithArgs("testuser", "key-1", "token-1", 402, "text/plain", []byte("insufficient funds")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM idempotency_keys WHERE username = \\$1 AND key = \\$2 AND reservation_token = \\$3 AND status_code IS NULL").
+		WithArgs("testuser", "key-2", "token-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := repo.CompleteIdempotencyKey(context.Background(), "testuser", "key-1", "token-1", models.IdempotentResponse{StatusCode: 402, ContentType: "text/plain", Body: []byte("insufficient funds")})
+	assert.NoError(t, err)
+
+	err = repo.ReleaseIdempotencyKey(context.Background(), "testuser", "key-2", "token-2")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateSession(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO sessions \\(id, username\\) VALUES \\(\\$1, \\$2\\)").
+		WithArgs("s1", "testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refresh_tokens \\(token_hash, session_id, expires_at\\) VALUES \\(\\$1, \\$2, CURRENT_TIMESTAMP \\+ make_interval\\(secs => \\$3\\)\\)").
+		WithArgs("hash", "s1", 3600.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.CreateSession(context.Background(), models.Session{ID: "s1", Username: "testuser"}, "hash", time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func expectRefreshToken(mock sqlmock.Sqlmock, hash string, used, expired, revoked bool) {
+	mock.ExpectQuery("SELECT s.id, s.username, rt.used_at IS NOT NULL, rt.expires_at < CURRENT_TIMESTAMP, s.revoked_at IS NOT NULL FROM refresh_tokens rt JOIN sessions s ON s.id = rt.session_id WHERE rt.token_hash = \\$1 FOR UPDATE").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "used", "expired", "revoked"}).AddRow("s1", "testuser", used, expired, revoked))
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectRefreshToken(mock, "old", false, false, false)
+	mock.ExpectExec("UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = \\$1").
+		WithArgs("old").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refresh_tokens").
+		WithArgs("new", "s1", 3600.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	s, err := repo.RotateRefreshToken(context.Background(), "old", "new", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Session{ID: "s1", Username: "testuser"}, s)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRotateRefreshToken_ReuseRevokesSession(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectRefreshToken(mock, "old", true, false, false)
+	mock.ExpectExec("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = \\$1").
+		WithArgs("s1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err := repo.RotateRefreshToken(context.Background(), "old", "new", time.Hour)
+	assert.ErrorIs(t, err, session.ErrRefreshTokenReused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRotateRefreshToken_Invalid(t *testing.T) {
+	tests := []struct {
+		name             string
+		expired, revoked bool
+	}{
+		{"expired", true, false},
+		{"revoked session", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, repo := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			expectRefreshToken(mock, "old", false, tt.expired, tt.revoked)
+			mock.ExpectRollback()
+
+			_, err := repo.RotateRefreshToken(context.Background(), "old", "new", time.Hour)
+			assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		db, mock, repo := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT s.id").WithArgs("old").WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := repo.RotateRefreshToken(context.Background(), "old", "new", time.Hour)
+		assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRevokeSessionAndSessionActive(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = \\$1 AND revoked_at IS NULL").
+		WithArgs("s1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM sessions WHERE id = \\$1 AND revoked_at IS NULL\\)").
+		WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	assert.NoError(t, repo.RevokeSession(context.Background(), "s1"))
+
+	active, err := repo.SessionActive(context.Background(), "s1")
+	assert.NoError(t, err)
+	assert.False(t, active)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdatePasswordHash(t *testing.T) {
+	db, mock, repo := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE users SET password_hash = \\$2 WHERE username = \\$1").
+		WithArgs("testuser", "newhash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET password_hash = \\$2 WHERE username = \\$1").
+		WithArgs("ghost", "newhash").
+		WillReturnResult(sqlmock.NewResult(0, 0))
 
+	assert.NoError(t, repo.UpdatePasswordHash("testuser", "newhash"))
+	assert.ErrorIs(t, repo.UpdatePasswordHash("ghost", "newhash"), ErrUserNotFound)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -4,7 +4,6 @@ import (
 	"net/http"
 	"time"
 
-	authMiddleware "github.com/atinyakov/go-musthave-diploma/pkg/middleware"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -12,6 +11,8 @@ import (
 type PostHandler interface {
 	Register(http.ResponseWriter, *http.Request)
 	Login(http.ResponseWriter, *http.Request)
+	TokenRefresh(http.ResponseWriter, *http.Request)
+	Logout(http.ResponseWriter, *http.Request)
 	Orders(http.ResponseWriter, *http.Request)
 	BalanceWithdraw(http.ResponseWriter, *http.Request)
 }
@@ -22,7 +23,24 @@ type GetHandler interface {
 	Withdrawals(http.ResponseWriter, *http.Request)
 }
 
-func New(post PostHandler, get GetHandler) *chi.Mux {
+type InternalHandler interface {
+	AccrualCallback(http.ResponseWriter, *http.Request)
+}
+
+type HealthHandler interface {
+	Health(http.ResponseWriter, *http.Request)
+}
+
+type IdempotencyHandler interface {
+	Handler(http.Handler) http.Handler
+}
+
+type AuthHandler interface {
+	Handler(http.Handler) http.Handler
+}
+
+// New builds the router. Internal routes are mounted only when internal is not nil.
+func New(post PostHandler, get GetHandler, internal InternalHandler, health HealthHandler, auth AuthHandler, idempotency IdempotencyHandler) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
@@ -38,19 +56,28 @@ func New(post PostHandler, get GetHandler) *chi.Mux {
 		_, _ = w.Write([]byte("hi"))
 	})
 
+	r.Get("/api/health", health.Health)
+
 	r.Route("/api/user", func(r chi.Router) {
 		r.Post("/register", post.Register)
 		r.Post("/login", post.Login)
+		r.Post("/token/refresh", post.TokenRefresh)
+		r.With(auth.Handler).Post("/logout", post.Logout)
 
 		// Secured Routes
-		r.With(authMiddleware.AuthMiddleware).Post("/orders", post.Orders)
-		r.With(authMiddleware.AuthMiddleware).Get("/orders", get.Orders)
+		r.With(auth.Handler).Post("/orders", post.Orders)
+		r.With(auth.Handler).Get("/orders", get.Orders)
 
-		r.With(authMiddleware.AuthMiddleware).Get("/balance", get.Balance)
-		r.With(authMiddleware.AuthMiddleware).Post("/balance/withdraw", post.BalanceWithdraw)
+		r.With(auth.Handler).Get("/balance", get.Balance)
+		r.With(auth.Handler, idempotency.Handler).Post("/balance/withdraw", post.BalanceWithdraw)
 
-		r.With(authMiddleware.AuthMiddleware).Get("/withdrawals", get.Withdrawals)
+		r.With(auth.Handler).Get("/withdrawals", get.Withdrawals)
 	})
 
+	if internal != nil {
+		// Requests are authenticated by the HMAC signature checked in the handler
+		r.Post("/api/internal/accrual/callback", internal.AccrualCallback)
+	}
+
 	return r
 }
@@ -3,8 +3,9 @@ package service
 import (
 	"context"
 	"errors"
-	"math"
+	"log/slog"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
@@ -17,10 +18,12 @@ import (
 type Repository interface {
 	CreateUser(string, string) error
 	GetPasswordHashByUsername(string) (string, error)
+	UpdatePasswordHash(string, string) error
 	CreateOrder(context.Context, models.Order) (*models.Order, bool, error)
-	GetOrdersByUsername(ctx context.Context, username string) ([]models.Order, error)
-	GetWithdrawalsByUsername(ctx context.Context, username string) ([]models.Order, error)
-	GetUserBalanceAndWithdrawals(ctx context.Context, username string) (float64, float64, error)
+	GetOrders(ctx context.Context, q models.OrderQuery) ([]models.Order, error)
+	CreateWithdrawal(ctx context.Context, w models.Withdrawal) error
+	GetWithdrawalsByUsername(ctx context.Context, username string) ([]models.Withdrawal, error)
+	GetUserBalanceAndWithdrawals(ctx context.Context, username string) (models.Amount, models.Amount, error)
 }
 
 type Service struct {
@@ -34,6 +37,7 @@ func New(repo Repository) *Service {
 var ErrInvalidLuhn = errors.New("order is invalid")
 var ErrExists = errors.New("order already exists")
 var ErrNotBelongsToUser = errors.New("order is created by another user")
+var ErrInvalidSum = errors.New("withdrawal sum must be positive")
 
 func (r *Service) Register(login string, password string) error {
 	hashedPassword, err := auth.HashPassword(password)
@@ -52,23 +56,57 @@ func (r *Service) Register(login string, password string) error {
 	return nil
 }
 
+// dummyPasswordHash is verified for unknown users, so that the response time doesn't
+// tell whether a login exists. It is made with the current argon2id parameters.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword("dummy password")
+	if err != nil {
+		slog.Error("Failed to make dummy password hash", slog.String("error", err.Error()))
+	}
+	return hash
+})
+
 func (r *Service) Login(login string, password string) (bool, error) {
 	hashedPassword, err := r.repo.GetPasswordHashByUsername(login)
 	if errors.Is(err, repository.ErrUserNotFound) {
+		auth.VerifyPassword(dummyPasswordHash(), password)
 		return false, errors.New("invalid username or password")
 	}
 	if err != nil {
 		return false, err
 	}
 
-	isValid := auth.CheckPassword(hashedPassword, password)
+	isValid, needsRehash := auth.VerifyPassword(hashedPassword, password)
 
 	if !isValid {
 		return false, errors.New("invalid username or password")
 	}
+
+	// The hash is upgraded while the plain password is at hand; login succeeds either way
+	if needsRehash {
+		r.rehashPassword(login, password)
+	}
+
 	return true, nil
 }
 
+// rehashPassword replaces a legacy or outdated password hash with a current one
+func (r *Service) rehashPassword(login, password string) {
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		slog.Error("Failed to rehash password", slog.String("username", login), slog.String("error", err.Error()))
+		return
+	}
+
+	err = r.repo.UpdatePasswordHash(login, hashedPassword)
+	if err != nil {
+		slog.Error("Failed to store rehashed password", slog.String("username", login), slog.String("error", err.Error()))
+		return
+	}
+
+	slog.Info("Password hash upgraded", slog.String("username", login))
+}
+
 func (r *Service) CreateOrder(orderNumber int, username string) error {
 	isValid := luhn.Valid(orderNumber)
 
@@ -97,11 +135,52 @@ func (r *Service) CreateOrder(orderNumber int, username string) error {
 	return nil
 }
 
-func (r *Service) GetOrdersByUsername(username string) ([]models.Order, error) {
+// GetOrders returns a page of orders and the cursor of the next page, nil on the last page
+func (r *Service) GetOrders(q models.OrderQuery) ([]dto.OrderResponseItem, *models.OrderCursor, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return r.repo.GetOrdersByUsername(ctx, username)
+	limit := q.Limit
+	if limit > 0 {
+		// One more order tells whether there is a next page
+		q.Limit++
+	}
+
+	orders, err := r.repo.GetOrders(ctx, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var next *models.OrderCursor
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+		cursor := models.CursorOf(orders[limit-1])
+		next = &cursor
+	}
+
+	res := make([]dto.OrderResponseItem, 0, len(orders))
+	for _, o := range orders {
+		res = append(res, toOrderResponse(o))
+	}
+
+	return res, next, nil
+}
+
+// toOrderResponse reports the accrual only once the order is PROCESSED, as the
+// accrual system doesn't compute it before
+func toOrderResponse(o models.Order) dto.OrderResponseItem {
+	item := dto.OrderResponseItem{
+		Number:     o.Number,
+		Status:     o.Status,
+		UploadedAt: o.UploadedAt.Format(time.RFC3339),
+	}
+
+	if o.Status == models.StatusProcessed {
+		accrual := o.Accrual
+		item.Accrual = &accrual
+	}
+
+	return item
 }
 
 func (r *Service) CreateWidthraw(req dto.WithdrawalRequest, username string) error {
@@ -116,12 +195,15 @@ func (r *Service) CreateWidthraw(req dto.WithdrawalRequest, username string) err
 		return ErrInvalidLuhn
 	}
 
+	// A negative sum would credit points, so it is rejected regardless of the repository
+	if req.Sum <= 0 {
+		return ErrInvalidSum
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, _, err = r.repo.CreateOrder(ctx, models.Order{Number: req.Order, Username: username, Accrual: -float64(req.Sum)})
-
-	return err
+	return r.repo.CreateWithdrawal(ctx, models.Withdrawal{Order: req.Order, Username: username, Sum: req.Sum})
 }
 
 func (r *Service) GetBalance(username string) (dto.BalanceResponce, error) {
@@ -133,7 +215,7 @@ func (r *Service) GetBalance(username string) (dto.BalanceResponce, error) {
 		return dto.BalanceResponce{}, err
 	}
 
-	return dto.BalanceResponce{Current: float32(RoundTo(balance, 2)), Withdrawn: float32(RoundTo(widthdraw, 2))}, nil
+	return dto.BalanceResponce{Current: balance, Withdrawn: widthdraw}, nil
 }
 
 func (r *Service) GetWithdrawals(username string) ([]dto.WithdrawalResponseItem, error) {
@@ -149,13 +231,8 @@ func (r *Service) GetWithdrawals(username string) ([]dto.WithdrawalResponseItem,
 	var res []dto.WithdrawalResponseItem
 
 	for _, w := range widthdrawals {
-		res = append(res, dto.WithdrawalResponseItem{ProcessedAt: w.UploadedAt, Order: w.Number, Sum: RoundTo(w.Accrual, 2)})
+		res = append(res, dto.WithdrawalResponseItem{ProcessedAt: w.ProcessedAt, Order: w.Order, Sum: w.Sum})
 	}
 
 	return res, nil
 }
-
-func RoundTo(value float64, places int) float64 {
-	factor := math.Pow10(places)
-	return math.Round(value*factor) / factor
-}
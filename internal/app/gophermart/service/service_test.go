@@ -1,8 +1,14 @@
 package service_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
@@ -68,6 +74,78 @@ func TestLogin(t *testing.T) {
 	assert.False(t, valid)
 }
 
+func TestLogin_UnknownUserTakesAsLong(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	srv := service.New(mockRepo)
+
+	hashedPassword, err := auth.HashPassword("testpassword")
+	assert.NoError(t, err)
+	mockRepo.EXPECT().GetPasswordHashByUsername("testuser").Return(hashedPassword, nil).AnyTimes()
+	mockRepo.EXPECT().GetPasswordHashByUsername("unknown").Return("", repository.ErrUserNotFound).AnyTimes()
+
+	// The fastest of a few runs keeps scheduling noise out
+	fastest := func(login string) time.Duration {
+		best := time.Duration(math.MaxInt64)
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			_, err := srv.Login(login, "wrong")
+			assert.Error(t, err)
+			best = min(best, time.Since(start))
+		}
+		return best
+	}
+
+	existing, unknown := fastest("testuser"), fastest("unknown")
+	assert.Greater(t, unknown, existing/3, "an unknown login must be checked against a password hash as well")
+}
+
+func TestLogin_RehashesLegacyPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	srv := service.New(mockRepo)
+
+	// Hash in the format used before argon2id
+	legacy := legacyHash("salt", "testpassword")
+
+	mockRepo.EXPECT().GetPasswordHashByUsername("testuser").Return(legacy, nil)
+	mockRepo.EXPECT().UpdatePasswordHash("testuser", gomock.Any()).
+		DoAndReturn(func(_, hashed string) error {
+			valid, needsRehash := auth.VerifyPassword(hashed, "testpassword")
+			assert.True(t, valid)
+			assert.False(t, needsRehash)
+			return nil
+		})
+
+	valid, err := srv.Login("testuser", "testpassword")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	// A failed rehash doesn't fail the login
+	mockRepo.EXPECT().GetPasswordHashByUsername("testuser").Return(legacy, nil)
+	mockRepo.EXPECT().UpdatePasswordHash("testuser", gomock.Any()).Return(errors.New("db is down"))
+
+	valid, err = srv.Login("testuser", "testpassword")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	// Wrong password against a legacy hash is not rehashed
+	mockRepo.EXPECT().GetPasswordHashByUsername("testuser").Return(legacy, nil)
+
+	valid, err = srv.Login("testuser", "wrong")
+	assert.Error(t, err)
+	assert.False(t, valid)
+}
+
+func legacyHash(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return hex.EncodeToString([]byte(salt)) + "$" + hex.EncodeToString(sum[:])
+}
+
 func TestCreateOrder(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -94,23 +172,93 @@ func TestCreateOrder(t *testing.T) {
 	assert.Equal(t, service.ErrExists, err)
 }
 
-func TestGetOrdersByUsername(t *testing.T) {
+func TestGetOrders(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockRepo := mocks.NewMockRepository(ctrl)
-	service := service.New(mockRepo)
+	srv := service.New(mockRepo)
 
-	username := "testuser"
-	expectedOrders := []models.Order{
-		{Number: "123", Username: username, Status: models.StatusNew},
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	orders := []models.Order{
+		{Number: "3", Status: models.StatusProcessed, Accrual: models.NewAmount(500), UploadedAt: at.Add(2 * time.Hour)},
+		{Number: "2", Status: models.StatusProcessing, UploadedAt: at.Add(time.Hour)},
+		{Number: "1", Status: models.StatusInvalid, UploadedAt: at},
 	}
 
-	mockRepo.EXPECT().GetOrdersByUsername(gomock.Any(), username).Return(expectedOrders, nil)
+	accrual := models.NewAmount(500)
+	expected := []dto.OrderResponseItem{
+		{Number: "3", Status: models.StatusProcessed, Accrual: &accrual, UploadedAt: "2025-01-01T02:00:00Z"},
+		{Number: "2", Status: models.StatusProcessing, UploadedAt: "2025-01-01T01:00:00Z"},
+		{Number: "1", Status: models.StatusInvalid, UploadedAt: "2025-01-01T00:00:00Z"},
+	}
+
+	t.Run("more orders than the limit", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrders(gomock.Any(), models.OrderQuery{Username: "testuser", Limit: 3}).Return(orders, nil)
+
+		page, next, err := srv.GetOrders(models.OrderQuery{Username: "testuser", Limit: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, expected[:2], page)
+		assert.Equal(t, &models.OrderCursor{UploadedAt: at.Add(time.Hour), Number: "2"}, next)
+	})
+
+	t.Run("last page", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrders(gomock.Any(), models.OrderQuery{Username: "testuser", Limit: 4}).Return(orders, nil)
+
+		page, next, err := srv.GetOrders(models.OrderQuery{Username: "testuser", Limit: 3})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, page)
+		assert.Nil(t, next)
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		mockRepo.EXPECT().GetOrders(gomock.Any(), models.OrderQuery{Username: "testuser"}).Return(orders, nil)
+
+		page, next, err := srv.GetOrders(models.OrderQuery{Username: "testuser"})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, page)
+		assert.Nil(t, next)
+	})
+}
+
+// TestGetOrders_Response checks the listing against the example in SPECIFICATION.md
+func TestGetOrders_Response(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mocks.NewMockRepository(ctrl)
+	srv := service.New(mockRepo)
 
-	orders, err := service.GetOrdersByUsername(username)
+	msk := time.FixedZone("MSK", 3*60*60)
+	mockRepo.EXPECT().GetOrders(gomock.Any(), models.OrderQuery{Username: "testuser"}).Return([]models.Order{
+		{Username: "testuser", Number: "9278923470", Status: models.StatusProcessed, Accrual: models.NewAmount(500), UploadedAt: time.Date(2020, 12, 10, 15, 15, 45, 123000000, msk)},
+		{Username: "testuser", Number: "12345678903", Status: models.StatusProcessing, UploadedAt: time.Date(2020, 12, 10, 15, 12, 1, 0, msk)},
+		{Username: "testuser", Number: "346436439", Status: models.StatusInvalid, UploadedAt: time.Date(2020, 12, 9, 16, 9, 53, 0, msk)},
+	}, nil)
+
+	page, _, err := srv.GetOrders(models.OrderQuery{Username: "testuser"})
+	assert.NoError(t, err)
+
+	got, err := json.Marshal(page)
 	assert.NoError(t, err)
-	assert.Equal(t, expectedOrders, orders)
+	assert.JSONEq(t, `[
+		{
+			"number": "9278923470",
+			"status": "PROCESSED",
+			"accrual": 500,
+			"uploaded_at": "2020-12-10T15:15:45+03:00"
+		},
+		{
+			"number": "12345678903",
+			"status": "PROCESSING",
+			"uploaded_at": "2020-12-10T15:12:01+03:00"
+		},
+		{
+			"number": "346436439",
+			"status": "INVALID",
+			"uploaded_at": "2020-12-09T16:09:53+03:00"
+		}
+	]`, string(got))
 }
 
 func TestCreateWithdraw(t *testing.T) {
@@ -121,17 +269,28 @@ func TestCreateWithdraw(t *testing.T) {
 	srv := service.New(mockRepo)
 
 	username := "testuser"
-	req := dto.WithdrawalRequest{Order: "123", Sum: 100}
-	validReq := dto.WithdrawalRequest{Order: "4012888888881881", Sum: 100}
+	req := dto.WithdrawalRequest{Order: "123", Sum: models.NewAmount(100)}
+	validReq := dto.WithdrawalRequest{Order: "4012888888881881", Sum: models.NewAmount(100)}
 
 	// Test invalid Luhn number
 	err := srv.CreateWidthraw(req, username)
 	assert.EqualError(t, err, service.ErrInvalidLuhn.Error())
 
+	// Test zero and negative sums, which never reach the repository
+	for _, sum := range []models.Amount{0, models.NewAmount(-100)} {
+		err = srv.CreateWidthraw(dto.WithdrawalRequest{Order: validReq.Order, Sum: sum}, username)
+		assert.ErrorIs(t, err, service.ErrInvalidSum)
+	}
+
 	// Test valid withdrawal
-	mockRepo.EXPECT().CreateOrder(gomock.Any(), gomock.Any()).Return(&models.Order{}, false, nil)
+	mockRepo.EXPECT().CreateWithdrawal(gomock.Any(), models.Withdrawal{Order: validReq.Order, Username: username, Sum: models.NewAmount(100)}).Return(nil)
 	err = srv.CreateWidthraw(validReq, username)
 	assert.NoError(t, err)
+
+	// Test order already paid with points
+	mockRepo.EXPECT().CreateWithdrawal(gomock.Any(), gomock.Any()).Return(repository.ErrWithdrawalExists)
+	err = srv.CreateWidthraw(validReq, username)
+	assert.ErrorIs(t, err, repository.ErrWithdrawalExists)
 }
 
 func TestGetBalance(t *testing.T) {
@@ -142,15 +301,15 @@ func TestGetBalance(t *testing.T) {
 	service := service.New(mockRepo)
 
 	username := "testuser"
-	expectedBalance := 100.0
-	expectedWithdrawals := 50.0
+	expectedBalance := models.NewAmount(100)
+	expectedWithdrawals := models.NewAmount(50)
 
 	mockRepo.EXPECT().GetUserBalanceAndWithdrawals(gomock.Any(), username).Return(expectedBalance, expectedWithdrawals, nil)
 
 	balance, err := service.GetBalance(username)
 	assert.NoError(t, err)
-	assert.Equal(t, float32(100.0), balance.Current)
-	assert.Equal(t, float32(50.0), balance.Withdrawn)
+	assert.Equal(t, models.NewAmount(100), balance.Current)
+	assert.Equal(t, models.NewAmount(50), balance.Withdrawn)
 }
 
 func TestGetWithdrawals(t *testing.T) {
@@ -161,8 +320,8 @@ func TestGetWithdrawals(t *testing.T) {
 	service := service.New(mockRepo)
 
 	username := "testuser"
-	expectedWithdrawals := []models.Order{
-		{Number: "123", Username: username, Accrual: -100.0},
+	expectedWithdrawals := []models.Withdrawal{
+		{Order: "123", Username: username, Sum: models.NewAmount(100)},
 	}
 
 	mockRepo.EXPECT().GetWithdrawalsByUsername(gomock.Any(), username).Return(expectedWithdrawals, nil)
@@ -171,5 +330,5 @@ func TestGetWithdrawals(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, withdrawals, 1)
 	assert.Equal(t, "123", withdrawals[0].Order)
-	assert.Equal(t, -100.0, withdrawals[0].Sum)
+	assert.Equal(t, models.NewAmount(100), withdrawals[0].Sum)
 }
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	// ErrRefreshTokenReused means a refresh token was presented twice. It may have been
+	// stolen, so the whole session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+type Store interface {
+	// CreateSession stores the session with its first refresh token valid for ttl
+	CreateSession(ctx context.Context, s models.Session, refreshHash string, ttl time.Duration) error
+	// RotateRefreshToken marks the token as used and stores its replacement valid for ttl.
+	// A token used before revokes its session and returns the session with ErrRefreshTokenReused.
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, ttl time.Duration) (models.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	SessionActive(ctx context.Context, id string) (bool, error)
+}
+
+type TokenIssuer interface {
+	Generate(username, sessionID string) (string, error)
+}
+
+// Tokens are the credentials handed out on login and refresh
+type Tokens struct {
+	Access    string
+	Refresh   string
+	ExpiresIn time.Duration
+}
+
+// Manager issues short-lived access tokens bound to a session and opaque refresh tokens
+// that rotate on every use
+type Manager struct {
+	store      Store
+	tokens     TokenIssuer
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func New(store Store, tokens TokenIssuer, accessTTL, refreshTTL time.Duration) *Manager {
+	return &Manager{
+		store:      store,
+		tokens:     tokens,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// Start opens a session for the user who has just logged in
+func (m *Manager) Start(ctx context.Context, username string) (Tokens, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	refresh, err := randomString(32)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	s := models.Session{ID: id, Username: username}
+	err = m.store.CreateSession(ctx, s, hashToken(refresh), m.refreshTTL)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("create session: %w", err)
+	}
+
+	return m.issue(s, refresh)
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens of the same session
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	if refreshToken == "" {
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+
+	refresh, err := randomString(32)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	s, err := m.store.RotateRefreshToken(ctx, hashToken(refreshToken), hashToken(refresh), m.refreshTTL)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		slog.Warn("refresh token reused, session revoked", slog.String("session", s.ID), slog.String("username", s.Username))
+		return Tokens{}, err
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return m.issue(s, refresh)
+}
+
+// Revoke ends the session, its access and refresh tokens stop being accepted
+func (m *Manager) Revoke(ctx context.Context, sessionID string) error {
+	return m.store.RevokeSession(ctx, sessionID)
+}
+
+// Active reports whether access tokens of the session are still accepted
+func (m *Manager) Active(ctx context.Context, sessionID string) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+
+	return m.store.SessionActive(ctx, sessionID)
+}
+
+func (m *Manager) issue(s models.Session, refresh string) (Tokens, error) {
+	access, err := m.tokens.Generate(s.Username, s.ID)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("generate access token: %w", err)
+	}
+
+	return Tokens{Access: access, Refresh: refresh, ExpiresIn: m.accessTTL}, nil
+}
+
+// randomString returns n random bytes encoded for use in URLs and headers
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what the store keeps instead of the refresh token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
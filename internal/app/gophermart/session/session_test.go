@@ -0,0 +1,119 @@
+package session_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestManager_Start(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockStore(ctrl)
+	mockTokens := mocks.NewMockTokenIssuer(ctrl)
+	m := session.New(mockStore, mockTokens, 15*time.Minute, 24*time.Hour)
+
+	var stored models.Session
+	var storedHash string
+	mockStore.EXPECT().CreateSession(gomock.Any(), gomock.Any(), gomock.Any(), 24*time.Hour).
+		DoAndReturn(func(_ context.Context, s models.Session, refreshHash string, _ time.Duration) error {
+			stored, storedHash = s, refreshHash
+			return nil
+		})
+	mockTokens.EXPECT().Generate("testuser", gomock.Any()).
+		DoAndReturn(func(_, sessionID string) (string, error) {
+			assert.Equal(t, stored.ID, sessionID)
+			return "access", nil
+		})
+
+	tokens, err := m.Start(context.Background(), "testuser")
+	require.NoError(t, err)
+
+	assert.Equal(t, "testuser", stored.Username)
+	assert.NotEmpty(t, stored.ID)
+	assert.Equal(t, "access", tokens.Access)
+	assert.Equal(t, 15*time.Minute, tokens.ExpiresIn)
+	assert.Equal(t, hash(tokens.Refresh), storedHash, "only the hash of the refresh token is stored")
+}
+
+func TestManager_Refresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockStore(ctrl)
+	mockTokens := mocks.NewMockTokenIssuer(ctrl)
+	m := session.New(mockStore, mockTokens, 15*time.Minute, 24*time.Hour)
+
+	s := models.Session{ID: "s1", Username: "testuser"}
+
+	t.Run("rotates the refresh token", func(t *testing.T) {
+		var newHash string
+		mockStore.EXPECT().RotateRefreshToken(gomock.Any(), hash("old"), gomock.Any(), 24*time.Hour).
+			DoAndReturn(func(_ context.Context, _, h string, _ time.Duration) (models.Session, error) {
+				newHash = h
+				return s, nil
+			})
+		mockTokens.EXPECT().Generate("testuser", "s1").Return("access", nil)
+
+		tokens, err := m.Refresh(context.Background(), "old")
+		require.NoError(t, err)
+
+		assert.Equal(t, "access", tokens.Access)
+		assert.NotEqual(t, "old", tokens.Refresh)
+		assert.Equal(t, hash(tokens.Refresh), newHash)
+	})
+
+	t.Run("reuse", func(t *testing.T) {
+		mockStore.EXPECT().RotateRefreshToken(gomock.Any(), hash("old"), gomock.Any(), gomock.Any()).Return(s, session.ErrRefreshTokenReused)
+
+		_, err := m.Refresh(context.Background(), "old")
+		assert.ErrorIs(t, err, session.ErrRefreshTokenReused)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		storeErr := errors.New("db is down")
+		mockStore.EXPECT().RotateRefreshToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(models.Session{}, storeErr)
+
+		_, err := m.Refresh(context.Background(), "old")
+		assert.ErrorIs(t, err, storeErr)
+	})
+
+	t.Run("empty token", func(t *testing.T) {
+		_, err := m.Refresh(context.Background(), "")
+		assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+	})
+}
+
+func TestManager_Active(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockStore(ctrl)
+	m := session.New(mockStore, mocks.NewMockTokenIssuer(ctrl), time.Minute, time.Hour)
+
+	mockStore.EXPECT().SessionActive(gomock.Any(), "s1").Return(false, nil)
+	active, err := m.Active(context.Background(), "s1")
+	require.NoError(t, err)
+	assert.False(t, active)
+
+	// Tokens issued before sessions were introduced have no session
+	active, err = m.Active(context.Background(), "")
+	require.NoError(t, err)
+	assert.False(t, active)
+}
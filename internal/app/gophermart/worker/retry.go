@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// retryPolicy describes how the worker reacts to a failed accrual request
+type retryPolicy struct {
+	// retries is how many times the same order is requested again right away
+	retries int
+	// delay is the pause between immediate retries
+	delay time.Duration
+	// halt stops the current poll and backs off before the next one
+	halt bool
+}
+
+// policyFor picks a retry policy for an error returned by the accrual client.
+// Errors not listed here are left for the next poll.
+func policyFor(err error) retryPolicy {
+	switch {
+	case errors.Is(err, client.ErrTimeout):
+		return retryPolicy{retries: 2, delay: 200 * time.Millisecond}
+	case errors.Is(err, client.ErrServerError):
+		return retryPolicy{retries: 1, delay: time.Second}
+	case errors.Is(err, client.ErrConnectionRefused), errors.Is(err, client.ErrRequestFailed):
+		return retryPolicy{halt: true}
+	default:
+		return retryPolicy{}
+	}
+}
+
+// nextBackoff doubles the current backoff within [minBackoff, maxBackoff]
+func nextBackoff(current time.Duration) time.Duration {
+	if current < minBackoff {
+		return minBackoff
+	}
+	return min(current*2, maxBackoff)
+}
+
+// backoffFor returns the delay before the next poll of a job that failed attempts times in a row
+func backoffFor(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 0; i < attempts && delay < maxBackoff; i++ {
+		delay = nextBackoff(delay)
+	}
+	return delay
+}
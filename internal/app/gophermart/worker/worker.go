@@ -3,31 +3,73 @@ package worker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/breaker"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
 	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
 )
 
+const (
+	// batchSize limits how many orders are written in a single UpdateOrders call
+	batchSize = 100
+	// claimLimit limits how many jobs are taken from the queue per poll
+	claimLimit = 100
+	// jobLease is how long a claimed job is hidden from other workers and replicas
+	jobLease = 30 * time.Second
+)
+
+var ErrInvalidAccrual = errors.New("invalid accrual update")
+
 type Repo interface {
 	UpdateOrders(ctx context.Context, os []models.Order) error
-	GetOrdersByStatus(ctx context.Context) ([]models.Order, error)
+	ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error)
+	RescheduleAccrualJob(ctx context.Context, number string, delay time.Duration, reason string) error
 }
 
 type Client interface {
 	Request(context.Context, string) (*models.Order, error)
 }
 
+// availability is implemented by clients that know when the accrual system is
+// unavailable, such as a circuit breaker
+type availability interface {
+	Available() bool
+}
+
+// Options configures AccrualTaskWorker
+type Options struct {
+	// Workers is the number of goroutines querying the accrual system
+	Workers int
+	// UnregisteredTimeout is how long after upload an order unknown to the
+	// accrual system is retried before it is marked INVALID
+	UnregisteredTimeout time.Duration
+}
+
 type AccrualTaskWorker struct {
 	client Client
 	repo   Repo
+	opts   Options
+
+	mu         sync.Mutex
+	backoff    time.Duration
+	pauseUntil time.Time
 }
 
-func NewAccrualTaskWorker(repo Repo, client Client) *AccrualTaskWorker {
+func NewAccrualTaskWorker(repo Repo, client Client, opts Options) *AccrualTaskWorker {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
 	return &AccrualTaskWorker{
 		client: client,
 		repo:   repo,
+		opts:   opts,
 	}
 }
 
@@ -41,39 +83,233 @@ func (s *AccrualTaskWorker) StartOrderFetcher(ctx context.Context) {
 			slog.Info("StartOrderFetcher shutting down...")
 			return
 		case <-ticker.C:
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-			slog.Info("StartOrderFetcher getting orders...")
-
-			newOrders, err := s.repo.GetOrdersByStatus(ctx)
-			if err != nil {
-				slog.Error("Failed to fetch new orders: %s\n", slog.String("error", err.Error()))
+			if s.paused() || !s.available() {
 				continue
 			}
+			s.poll(ctx)
+		}
+	}
+}
+
+// ApplyAccrual stores an accrual update pushed by the accrual system,
+// using the same path as the results of polling
+func (s *AccrualTaskWorker) ApplyAccrual(ctx context.Context, accrual dto.AccrualResponse) error {
+	if accrual.Order == "" {
+		return fmt.Errorf("%w: order is required", ErrInvalidAccrual)
+	}
+
+	switch accrual.Status {
+	case "REGISTERED", "PROCESSING", "INVALID", "PROCESSED":
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidAccrual, accrual.Status)
+	}
+
+	if accrual.Accrual < 0 {
+		return fmt.Errorf("%w: accrual must not be negative", ErrInvalidAccrual)
+	}
+
+	return s.repo.UpdateOrders(ctx, []models.Order{client.ToOrder(accrual)})
+}
 
-			if len(newOrders) == 0 {
+// poll claims due accrual jobs, queries the accrual system for them using
+// a pool of s.opts.Workers goroutines and stores the results in batches
+func (s *AccrualTaskWorker) poll(ctx context.Context) {
+	fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	slog.Info("StartOrderFetcher getting orders...")
+
+	dueJobs, err := s.repo.ClaimAccrualJobs(fetchCtx, claimLimit, jobLease)
+	if err != nil {
+		slog.Error("Failed to fetch new orders: %s\n", slog.String("error", err.Error()))
+		return
+	}
+
+	if len(dueJobs) == 0 {
+		return
+	}
+
+	// passCtx is cancelled when the accrual system turns out to be unavailable
+	passCtx, halt := context.WithCancel(ctx)
+	defer halt()
+
+	jobs := make(chan models.AccrualJob)
+	results := make(chan models.Order, len(dueJobs))
+	var halted atomic.Bool
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(s.opts.Workers, len(dueJobs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.fetch(passCtx, jobs, results, func() {
+				halted.Store(true)
+				halt()
+			})
+		}()
+	}
+
+dispatch:
+	for _, job := range dueJobs {
+		select {
+		case <-passCtx.Done():
+			break dispatch
+		case jobs <- job:
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+	close(results)
+
+	if halted.Load() {
+		s.backOff()
+	} else {
+		s.resetBackoff()
+	}
+
+	// Results that were already fetched are stored even if ctx is cancelled
+	s.store(context.WithoutCancel(ctx), results)
+}
+
+// fetch requests accrual information for every job received from jobs.
+// halt is called when the accrual system is unavailable.
+func (s *AccrualTaskWorker) fetch(ctx context.Context, jobs <-chan models.AccrualJob, results chan<- models.Order, halt func()) {
+	for job := range jobs {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		order := job.Order
+		res, err := s.request(ctx, order.Number)
+		if err != nil {
+			// A cancelled or rejected job becomes due again once its lease expires
+			if errors.Is(err, context.Canceled) || errors.Is(err, breaker.ErrOpen) {
 				continue
 			}
 
-			for _, order := range newOrders {
-				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-				defer cancel()
-				res, err := s.client.Request(ctx, order.Number)
-
-				var rae client.RetryAfterErr
-				if errors.As(err, &rae) {
-					slog.Info("sleeping %d seconds\n", slog.Int("time", rae.T))
-					time.Sleep(time.Duration(rae.T * int(time.Second)))
+			if errors.Is(err, client.ErrOrderNotRegistered) {
+				if expired := s.handleUnregistered(ctx, job); expired != nil {
+					results <- *expired
 				}
+				continue
+			}
 
-				if res != nil {
-					err = s.repo.UpdateOrders(ctx, append([]models.Order{}, *res))
-					if err != nil {
-						slog.Error("Failed to update orders: %s\n", slog.String("error", err.Error()))
-					}
-				}
+			// The client pauses every accrual call on 429, the job is retried after the window
+			var rae client.RetryAfterErr
+			if errors.As(err, &rae) {
+				slog.Info("accrual system is rate limiting", slog.String("order", order.Number), slog.Int("retry_after", rae.T))
+				s.reschedule(ctx, order.Number, time.Duration(rae.T)*time.Second, err)
+				continue
 			}
 
+			slog.Error("accrual request failed", slog.String("order", order.Number), slog.String("error", err.Error()))
+			s.reschedule(ctx, order.Number, backoffFor(job.Attempts), err)
+			if policyFor(err).halt {
+				halt()
+			}
+			continue
+		}
+
+		results <- *res
+	}
+}
+
+// handleUnregistered postpones a job for an order the accrual system does not know yet.
+// Once UnregisteredTimeout has passed since upload the order is returned as INVALID.
+func (s *AccrualTaskWorker) handleUnregistered(ctx context.Context, job models.AccrualJob) *models.Order {
+	order := job.Order
+	if job.Age > s.opts.UnregisteredTimeout {
+		slog.Info("order is still not registered in accrual system, marking invalid", slog.String("order", order.Number))
+		return &models.Order{Number: order.Number, Status: models.StatusInvalid}
+	}
+
+	delay := backoffFor(job.Attempts)
+	slog.Info("order is not registered in accrual system yet", slog.String("order", order.Number), slog.Duration("retry_in", delay))
+	s.reschedule(ctx, order.Number, delay, client.ErrOrderNotRegistered)
+	return nil
+}
+
+// reschedule stores the failed attempt so the job is polled again after delay
+func (s *AccrualTaskWorker) reschedule(ctx context.Context, number string, delay time.Duration, reason error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 3*time.Second)
+	defer cancel()
+
+	err := s.repo.RescheduleAccrualJob(ctx, number, delay, reason.Error())
+	if err != nil {
+		slog.Error("Failed to reschedule accrual job", slog.String("order", number), slog.String("error", err.Error()))
+	}
+}
+
+// request calls the accrual client, retrying in place as allowed by policyFor
+func (s *AccrualTaskWorker) request(ctx context.Context, number string) (*models.Order, error) {
+	res, err := s.client.Request(ctx, number)
+	for attempt := 0; err != nil && attempt < policyFor(err).retries; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(policyFor(err).delay):
+		}
+
+		res, err = s.client.Request(ctx, number)
+	}
+	return res, err
+}
+
+// available reports whether the client accepts requests at the moment
+func (s *AccrualTaskWorker) available() bool {
+	a, ok := s.client.(availability)
+	return !ok || a.Available()
+}
+
+// paused reports whether polling is suspended after the accrual system became unavailable
+func (s *AccrualTaskWorker) paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Now().Before(s.pauseUntil)
+}
+
+func (s *AccrualTaskWorker) backOff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backoff = nextBackoff(s.backoff)
+	s.pauseUntil = time.Now().Add(s.backoff)
+	slog.Info("accrual system unavailable, polling paused", slog.Duration("for", s.backoff))
+}
+
+func (s *AccrualTaskWorker) resetBackoff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backoff = 0
+	s.pauseUntil = time.Time{}
+}
+
+// store writes results to the repository in chunks of batchSize
+func (s *AccrualTaskWorker) store(ctx context.Context, results <-chan models.Order) {
+	batch := make([]models.Order, 0, batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		updateCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		err := s.repo.UpdateOrders(updateCtx, batch)
+		if err != nil {
+			slog.Error("Failed to update orders: %s\n", slog.String("error", err.Error()))
+		}
+		batch = make([]models.Order, 0, batchSize)
+	}
+
+	for res := range results {
+		batch = append(batch, res)
+		if len(batch) == batchSize {
+			flush()
 		}
 	}
+	flush()
 }
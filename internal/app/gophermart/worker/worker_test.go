@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/client"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/dto"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/mocks"
+	"github.com/atinyakov/go-musthave-diploma/internal/app/gophermart/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func newTestWorker(t *testing.T, workers int) (*AccrualTaskWorker, *mocks.MockRepo, *mocks.MockClient) {
+	ctrl := gomock.NewController(t)
+
+	mockRepo := mocks.NewMockRepo(ctrl)
+	mockClient := mocks.NewMockClient(ctrl)
+	w := NewAccrualTaskWorker(mockRepo, mockClient, Options{Workers: workers, UnregisteredTimeout: time.Hour})
+
+	return w, mockRepo, mockClient
+}
+
+func jobsFor(numbers ...string) []models.AccrualJob {
+	jobs := make([]models.AccrualJob, 0, len(numbers))
+	for _, n := range numbers {
+		jobs = append(jobs, models.AccrualJob{Order: models.Order{Number: n, Status: models.StatusNew, UploadedAt: time.Now()}})
+	}
+	return jobs
+}
+
+func TestPoll_FetchesConcurrentlyAndBatchesUpdates(t *testing.T) {
+	w, mockRepo, mockClient := newTestWorker(t, 3)
+
+	jobs := jobsFor("1", "2", "3")
+	mockRepo.EXPECT().ClaimAccrualJobs(gomock.Any(), claimLimit, jobLease).Return(jobs, nil)
+
+	var inFlight, maxInFlight int32
+	mockClient.EXPECT().Request(gomock.Any(), gomock.Any()).Times(len(jobs)).
+		DoAndReturn(func(_ context.Context, number string) (*models.Order, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+
+			return &models.Order{Number: number, Status: models.StatusProcessed, Accrual: models.NewAmount(10)}, nil
+		})
+
+	mockRepo.EXPECT().UpdateOrders(gomock.Any(), gomock.Len(len(jobs))).Return(nil)
+
+	w.poll(context.Background())
+
+	assert.Greater(t, atomic.LoadInt32(&maxInFlight), int32(1))
+}
+
+func TestPoll_NoJobs(t *testing.T) {
+	w, mockRepo, _ := newTestWorker(t, 3)
+
+	mockRepo.EXPECT().ClaimAccrualJobs(gomock.Any(), claimLimit, jobLease).Return([]models.AccrualJob{}, nil)
+
+	w.poll(context.Background())
+}
+
+func TestStartOrderFetcher_StopsOnCancel(t *testing.T) {
+	w, mockRepo, _ := newTestWorker(t, 2)
+
+	mockRepo.EXPECT().ClaimAccrualJobs(gomock.Any(), gomock.Any(), gomock.Any()).Return([]models.AccrualJob{}, nil).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.StartOrderFetcher(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StartOrderFetcher did not stop after context cancellation")
+	}
+}
+
+func TestPoll_HaltsAndBacksOffWhenAccrualUnavailable(t *testing.T) {
+	w, mockRepo, mockClient := newTestWorker(t, 1)
+
+	mockRepo.EXPECT().ClaimAccrualJobs(gomock.Any(), claimLimit, jobLease).Return(jobsFor("1", "2", "3"), nil)
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrConnectionRefused)
+	mockRepo.EXPECT().RescheduleAccrualJob(gomock.Any(), "1", minBackoff, client.ErrConnectionRefused.Error()).Return(nil)
+
+	w.poll(context.Background())
+
+	assert.True(t, w.paused())
+	assert.Equal(t, minBackoff, w.backoff)
+}
+
+func TestPoll_RetriesTimedOutRequest(t *testing.T) {
+	w, mockRepo, mockClient := newTestWorker(t, 1)
+
+	mockRepo.EXPECT().ClaimAccrualJobs(gomock.Any(), claimLimit, jobLease).Return(jobsFor("1"), nil)
+	gomock.InOrder(
+		mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrTimeout),
+		mockClient.EXPECT().Request(gomock.Any(), "1").Return(&models.Order{Number: "1", Status: models.StatusProcessed}, nil),
+	)
+	mockRepo.EXPECT().UpdateOrders(gomock.Any(), []models.Order{{Number: "1", Status: models.StatusProcessed}}).Return(nil)
+
+	w.poll(context.Background())
+
+	assert.False(t, w.paused())
+}
+
+func TestPoll_ReschedulesUnregisteredOrderWithGrowingDelay(t *testing.T) {
+	w, mockRepo, mockClient := newTestWorker(t, 1)
+
+	jobs := jobsFor("1")
+	jobs[0].Attempts = 2
+	mockRepo.EXPECT().ClaimAccrualJobs(gomock.Any(), claimLimit, jobLease).Return(jobs, nil)
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrOrderNotRegistered)
+	mockRepo.EXPECT().RescheduleAccrualJob(gomock.Any(), "1", 4*minBackoff, client.ErrOrderNotRegistered.Error()).Return(nil)
+
+	w.poll(context.Background())
+
+	assert.False(t, w.paused())
+}
+
+func TestPoll_MarksLongUnregisteredOrderInvalid(t *testing.T) {
+	w, mockRepo, mockClient := newTestWorker(t, 1)
+
+	jobs := jobsFor("1")
+	jobs[0].Age = 2 * time.Hour
+	mockRepo.EXPECT().ClaimAccrualJobs(gomock.Any(), claimLimit, jobLease).Return(jobs, nil)
+	mockClient.EXPECT().Request(gomock.Any(), "1").Return(nil, client.ErrOrderNotRegistered)
+	mockRepo.EXPECT().UpdateOrders(gomock.Any(), []models.Order{{Number: "1", Status: models.StatusInvalid}}).Return(nil)
+
+	w.poll(context.Background())
+}
+
+func TestBackoffFor(t *testing.T) {
+	assert.Equal(t, minBackoff, backoffFor(0))
+	assert.Equal(t, 2*minBackoff, backoffFor(1))
+	assert.Equal(t, 8*minBackoff, backoffFor(3))
+	assert.Equal(t, maxBackoff, backoffFor(100))
+}
+
+func TestApplyAccrual(t *testing.T) {
+	w, mockRepo, _ := newTestWorker(t, 1)
+
+	mockRepo.EXPECT().UpdateOrders(gomock.Any(), []models.Order{{Number: "1", Status: models.StatusProcessed, Accrual: models.NewAmount(500)}}).Return(nil)
+
+	err := w.ApplyAccrual(context.Background(), dto.AccrualResponse{Order: "1", Status: "PROCESSED", Accrual: models.NewAmount(500)})
+	assert.NoError(t, err)
+
+	err = w.ApplyAccrual(context.Background(), dto.AccrualResponse{Order: "1", Status: "DONE"})
+	assert.ErrorIs(t, err, ErrInvalidAccrual)
+
+	err = w.ApplyAccrual(context.Background(), dto.AccrualResponse{Status: "PROCESSED"})
+	assert.ErrorIs(t, err, ErrInvalidAccrual)
+}
+
+type unavailableClient struct {
+	*mocks.MockClient
+}
+
+func (unavailableClient) Available() bool { return false }
+
+func TestStartOrderFetcher_SkipsPollWhileClientUnavailable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mockRepo := mocks.NewMockRepo(ctrl)
+	w := NewAccrualTaskWorker(mockRepo, unavailableClient{mocks.NewMockClient(ctrl)}, Options{Workers: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 700*time.Millisecond)
+	defer cancel()
+
+	// No ClaimAccrualJobs call is expected
+	w.StartOrderFetcher(ctx)
+}
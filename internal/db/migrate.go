@@ -0,0 +1,252 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock identifies the advisory lock held while migrations run, so that
+// replicas starting at the same time don't apply them twice
+const migrationLock int64 = 7002
+
+// migrationName matches files such as 0001_create_users.up.sql
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrBadMigration = errors.New("bad migration")
+
+// Migration is a numbered schema change with the SQL to apply and to roll it back
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration is applied. AppliedAt is nil for pending migrations.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies the migrations embedded into the binary and records them in schema_migrations
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	return newMigrator(db, migrationFiles)
+}
+
+func newMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// loadMigrations reads the migrations of fsys sorted by version
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, path := range paths {
+		m := migrationName.FindStringSubmatch(path[len("migrations/"):])
+		if m == nil {
+			return nil, fmt.Errorf("%w: unexpected file name %s", ErrBadMigration, path)
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrBadMigration, path, err)
+		}
+
+		body, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("%w: version %d is used by %s and %s", ErrBadMigration, version, migration.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("%w: version %d has no up migration", ErrBadMigration, migration.Version)
+		}
+		res = append(res, *migration)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+// Up applies all pending migrations and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) (int, error) {
+		n := 0
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			if err != nil {
+				return n, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			slog.Info("migration applied", slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+			n++
+		}
+		return n, nil
+	})
+}
+
+// Down rolls back up to steps of the latest applied migrations and returns how many were rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) (int, error) {
+		n := 0
+		for i := len(m.migrations) - 1; i >= 0 && n < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if migration.Down == "" {
+				return n, fmt.Errorf("%w: migration %d_%s can't be rolled back", ErrBadMigration, migration.Version, migration.Name)
+			}
+
+			err := m.apply(ctx, conn, migration.Down, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			if err != nil {
+				return n, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			slog.Info("migration rolled back", slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+			n++
+		}
+		return n, nil
+	})
+}
+
+// Status lists all known migrations with the time they were applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	res := make([]MigrationStatus, 0, len(m.migrations))
+
+	_, err := m.locked(ctx, func(_ *sql.Conn, applied map[int64]time.Time) (int, error) {
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Migration: migration}
+			if at, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &at
+			}
+			res = append(res, status)
+		}
+		return 0, nil
+	})
+
+	return res, err
+}
+
+// locked runs f holding the migration lock on a dedicated connection
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn, applied map[int64]time.Time) (int, error)) (int, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock)
+	if err != nil {
+		return 0, fmt.Errorf("take migration lock: %w", err)
+	}
+
+	defer func() {
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLock)
+		if err != nil {
+			slog.Error("Failed to release migration lock", slog.String("error", err.Error()))
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return 0, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return f(conn, applied)
+}
+
+// apply runs the migration SQL and updates schema_migrations in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.ExecContext(ctx, migration)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version, "migrations must be numbered without gaps")
+		assert.NotEmpty(t, m.Up, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+}
+
+func TestLoadMigrations_Errors(t *testing.T) {
+	_, err := loadMigrations(fstest.MapFS{"migrations/create_users.sql": {Data: []byte("SELECT 1")}})
+	assert.ErrorIs(t, err, ErrBadMigration)
+
+	_, err = loadMigrations(fstest.MapFS{"migrations/0001_users.down.sql": {Data: []byte("SELECT 1")}})
+	assert.ErrorIs(t, err, ErrBadMigration)
+
+	_, err = loadMigrations(fstest.MapFS{
+		"migrations/0001_users.up.sql":  {Data: []byte("SELECT 1")},
+		"migrations/0001_orders.up.sql": {Data: []byte("SELECT 1")},
+	})
+	assert.ErrorIs(t, err, ErrBadMigration)
+}
+
+var testMigrations = fstest.MapFS{
+	"migrations/0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+	"migrations/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")},
+	"migrations/0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+	"migrations/0002_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+}
+
+func setupMigrator(t *testing.T, applied ...int64) (*Migrator, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	m, err := newMigrator(conn, testMigrations)
+	require.NoError(t, err)
+
+	mock.ExpectExec("SELECT pg_advisory_lock\\(\\$1\\)").WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range applied {
+		rows.AddRow(v, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(rows)
+
+	return m, mock
+}
+
+func TestMigrator_UpAppliesPending(t *testing.T) {
+	m, mock := setupMigrator(t, 1)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE b \\(\\);").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations \\(version, name\\) VALUES \\(\\$1, \\$2\\)").
+		WithArgs(int64(2), "create_b").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock\\(\\$1\\)").WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	n, err := m.Up(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_UpStopsOnFailure(t *testing.T) {
+	m, mock := setupMigrator(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE a \\(\\);").WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	n, err := m.Up(context.Background())
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_DownRollsBackLatest(t *testing.T) {
+	m, mock := setupMigrator(t, 1, 2)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE b;").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version = \\$1").
+		WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	n, err := m.Down(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Status(t *testing.T) {
+	m, mock := setupMigrator(t, 1)
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "create_a", statuses[0].Name)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.Equal(t, "create_b", statuses[1].Name)
+	assert.Nil(t, statuses[1].AppliedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
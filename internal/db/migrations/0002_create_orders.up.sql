@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS orders (
+    number TEXT PRIMARY KEY,      -- Order number as the unique identifier
+    username TEXT NOT NULL,       -- Foreign key linking to users table
+    status TEXT NOT NULL,         -- Order status
+    accrual FLOAT DEFAULT 0,      -- Accrual amount, defaulting to 0
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,  -- Timestamp of order creation
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
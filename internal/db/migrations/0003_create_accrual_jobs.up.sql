@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS accrual_jobs (
+    order_number TEXT PRIMARY KEY,                             -- Order polled in the accrual system
+    attempts INT NOT NULL DEFAULT 0,                           -- Failed attempts since the last successful poll
+    last_error TEXT,                                           -- Error of the last failed attempt
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, -- The job is not polled before this time
+    FOREIGN KEY (order_number) REFERENCES orders(number) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS accrual_jobs_next_attempt_at_idx ON accrual_jobs (next_attempt_at);
+INSERT INTO accrual_jobs (order_number)
+    SELECT number FROM orders WHERE status IN ('NEW', 'PROCESSING')
+    ON CONFLICT DO NOTHING;
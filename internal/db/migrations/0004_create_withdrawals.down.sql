@@ -0,0 +1,4 @@
+INSERT INTO orders (number, username, status, accrual, uploaded_at)
+    SELECT order_number, username, '', -sum, processed_at FROM withdrawals
+    ON CONFLICT DO NOTHING;
+DROP TABLE IF EXISTS withdrawals;
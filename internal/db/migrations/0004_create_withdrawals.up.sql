@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS withdrawals (
+    order_number TEXT PRIMARY KEY,                          -- Order paid with the withdrawn points
+    username TEXT NOT NULL,                                 -- Foreign key linking to users table
+    sum FLOAT NOT NULL CHECK (sum > 0),                     -- Withdrawn points
+    processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,       -- Timestamp of the withdrawal
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS withdrawals_username_idx ON withdrawals (username, processed_at DESC);
+
+-- Withdrawals used to be stored as orders with a negative accrual
+WITH moved AS (
+    DELETE FROM orders WHERE accrual < 0
+    RETURNING number, username, accrual, uploaded_at
+)
+INSERT INTO withdrawals (order_number, username, sum, processed_at)
+    SELECT number, username, -accrual, uploaded_at FROM moved;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS ledger_entries;
+DROP FUNCTION IF EXISTS ledger_entries_immutable();
+DROP SEQUENCE IF EXISTS ledger_posting_seq;
+DROP TABLE IF EXISTS ledger_accounts;
@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    name TEXT PRIMARY KEY,                                  -- "user:<username>" or "system:<purpose>"
+    balance FLOAT NOT NULL DEFAULT 0,                       -- Balance snapshot, kept for user accounts only
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP          -- Time of the last posting to the account
+);
+INSERT INTO ledger_accounts (name) VALUES ('system:accruals'), ('system:withdrawals'), ('system:adjustments')
+    ON CONFLICT DO NOTHING;
+CREATE SEQUENCE IF NOT EXISTS ledger_posting_seq;
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    posting_id BIGINT NOT NULL,                             -- Entries of one posting sum up to zero
+    account TEXT NOT NULL REFERENCES ledger_accounts(name), -- Account the entry belongs to
+    amount FLOAT NOT NULL,                                  -- Positive for credit, negative for debit
+    kind TEXT NOT NULL CHECK (kind IN ('ACCRUAL', 'WITHDRAWAL', 'ADJUSTMENT', 'REVERSAL')),
+    reference TEXT,                                         -- Order number or the reversed posting
+    description TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS ledger_entries_account_idx ON ledger_entries (account);
+CREATE INDEX IF NOT EXISTS ledger_entries_posting_idx ON ledger_entries (posting_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_once_idx ON ledger_entries (kind, reference, account)
+    WHERE kind IN ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL');
+CREATE OR REPLACE FUNCTION ledger_entries_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS ledger_entries_immutable ON ledger_entries;
+CREATE TRIGGER ledger_entries_immutable BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_entries_immutable();
+
+-- Balances of existing users are carried over as postings
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM ledger_entries) THEN
+        INSERT INTO ledger_accounts (name) SELECT 'user:' || username FROM users ON CONFLICT DO NOTHING;
+
+        WITH accrued AS (
+            SELECT nextval('ledger_posting_seq') AS posting_id, number, username, accrual, uploaded_at
+            FROM orders WHERE status = 'PROCESSED' AND accrual > 0
+        )
+        INSERT INTO ledger_entries (posting_id, account, amount, kind, reference, created_at)
+            SELECT posting_id, 'system:accruals', -accrual, 'ACCRUAL', number, uploaded_at FROM accrued
+            UNION ALL
+            SELECT posting_id, 'user:' || username, accrual, 'ACCRUAL', number, uploaded_at FROM accrued;
+
+        WITH withdrawn AS (
+            SELECT nextval('ledger_posting_seq') AS posting_id, order_number, username, sum, processed_at
+            FROM withdrawals
+        )
+        INSERT INTO ledger_entries (posting_id, account, amount, kind, reference, created_at)
+            SELECT posting_id, 'user:' || username, -sum, 'WITHDRAWAL', order_number, processed_at FROM withdrawn
+            UNION ALL
+            SELECT posting_id, 'system:withdrawals', sum, 'WITHDRAWAL', order_number, processed_at FROM withdrawn;
+
+        UPDATE ledger_accounts a
+        SET balance = COALESCE((SELECT SUM(amount) FROM ledger_entries e WHERE e.account = a.name), 0)
+        WHERE a.name LIKE 'user:%';
+    END IF;
+END
+$$;
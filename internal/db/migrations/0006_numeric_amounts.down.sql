@@ -0,0 +1,4 @@
+ALTER TABLE orders ALTER COLUMN accrual TYPE FLOAT;
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE FLOAT;
+ALTER TABLE ledger_accounts ALTER COLUMN balance TYPE FLOAT;
+ALTER TABLE ledger_entries ALTER COLUMN amount TYPE FLOAT;
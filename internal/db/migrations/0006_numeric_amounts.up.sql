@@ -0,0 +1,5 @@
+-- Points are fixed-point, FLOAT columns are rounded to hundredths
+ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(12,2) USING ROUND(accrual::numeric, 2);
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE NUMERIC(12,2) USING ROUND(sum::numeric, 2);
+ALTER TABLE ledger_accounts ALTER COLUMN balance TYPE NUMERIC(12,2) USING ROUND(balance::numeric, 2);
+ALTER TABLE ledger_entries ALTER COLUMN amount TYPE NUMERIC(12,2) USING ROUND(amount::numeric, 2);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    username TEXT NOT NULL,                                 -- Keys are scoped per user
+    key TEXT NOT NULL,                                      -- Value of the Idempotency-Key header
+    request_hash TEXT NOT NULL,                             -- SHA-256 of the method, path and body
+    status_code INT,                                        -- Recorded response, NULL while in progress
+    content_type TEXT,
+    response_body BYTEA,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (username, key),
+    FOREIGN KEY (username) REFERENCES users(username) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key TEXT PRIMARY KEY,                                   -- login:<username> or ip:<address>
+    failures INT NOT NULL,                                  -- Failed attempts since the last success
+    last_failure TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP                                  -- Attempts are rejected until then
+);
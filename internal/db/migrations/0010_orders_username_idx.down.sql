@@ -0,0 +1 @@
+DROP INDEX IF EXISTS orders_username_uploaded_at_idx;
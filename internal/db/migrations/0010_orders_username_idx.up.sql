@@ -0,0 +1,2 @@
+-- Serves the keyset pagination of a user's orders in both directions
+CREATE INDEX IF NOT EXISTS orders_username_uploaded_at_idx ON orders (username, uploaded_at DESC, number DESC);
@@ -0,0 +1,2 @@
+-- Pending reservations are leased, so that a key left behind by a crashed request can be taken over
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
@@ -0,0 +1,2 @@
+-- Only the request holding the reservation may record or release it, not one whose lease was taken over
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS reservation_token TEXT;
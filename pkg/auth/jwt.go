@@ -1,52 +1,185 @@
 package auth
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Secret key for signing tokens (store securely in env variables)
-var jwtSecret = []byte("my_secret_key")
+var (
+	ErrInvalidToken = errors.New("token is invalid")
+	ErrInvalidKey   = errors.New("invalid signing key")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
 
 // Claims struct (custom claims for the token)
 type Claims struct {
 	Username string `json:"username"`
+	// SessionID ties the token to a session so that it can be revoked
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT generates a new JWT token for the given username
-func GenerateJWT(username string) (string, error) {
-	expirationTime := time.Now().Add(8 * time.Hour) // Token expires in 8 hours
+// Key is a key tokens are signed or verified with. Its ID is put into the kid header.
+type Key struct {
+	ID     string
+	method jwt.SigningMethod
+	// sign is nil for keys that only verify tokens, e.g. public keys of rotated keys
+	sign   any
+	verify any
+}
+
+// Algorithm returns the JWT algorithm of the key
+func (k Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// CanSign tells whether the key holds the private part
+func (k Key) CanSign() bool {
+	return k.sign != nil
+}
+
+// NewHMACKey returns an HS256 key
+func NewHMACKey(id string, secret []byte) (Key, error) {
+	if len(secret) == 0 {
+		return Key{}, fmt.Errorf("%w: empty secret", ErrInvalidKey)
+	}
+
+	return Key{ID: id, method: jwt.SigningMethodHS256, sign: secret, verify: secret}, nil
+}
+
+// ParseKey reads a PEM encoded RSA (RS256) or Ed25519 (EdDSA) private or public key.
+// Anything else is taken as an HMAC secret.
+func ParseKey(id string, data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return NewHMACKey(id, bytes.TrimSpace(data))
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return Key{ID: id, method: jwt.SigningMethodRS256, sign: key, verify: &key.PublicKey}, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return Key{ID: id, method: jwt.SigningMethodRS256, sign: key, verify: &key.PublicKey}, nil
+		case ed25519.PrivateKey:
+			return Key{ID: id, method: jwt.SigningMethodEdDSA, sign: key, verify: key.Public()}, nil
+		}
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			return Key{ID: id, method: jwt.SigningMethodRS256, verify: key}, nil
+		case ed25519.PublicKey:
+			return Key{ID: id, method: jwt.SigningMethodEdDSA, verify: key}, nil
+		}
+	}
+
+	return Key{}, fmt.Errorf("%w: unsupported %s", ErrInvalidKey, block.Type)
+}
+
+// LoadKey reads a key file, see ParseKey
+func LoadKey(id, path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+
+	key, err := ParseKey(id, data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// JWT issues tokens signed with the current key and accepts tokens of any known key,
+// so that tokens issued before a key rotation stay valid until they expire
+type JWT struct {
+	signing Key
+	keys    map[string]Key
+	ttl     time.Duration
+}
+
+// NewJWT returns tokens signed with signing that expire after ttl. Tokens signed with
+// one of previous are still accepted.
+func NewJWT(ttl time.Duration, signing Key, previous ...Key) (*JWT, error) {
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("%w: key %q can't sign tokens", ErrInvalidKey, signing.ID)
+	}
+
+	keys := make(map[string]Key, len(previous)+1)
+	for _, key := range append([]Key{signing}, previous...) {
+		if _, ok := keys[key.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate key id %q", ErrInvalidKey, key.ID)
+		}
+		keys[key.ID] = key
+	}
+
+	return &JWT{signing: signing, keys: keys, ttl: ttl}, nil
+}
+
+// Generate generates a new JWT token for the given username and session
+func (j *JWT) Generate(username, sessionID string) (string, error) {
+	now := time.Now()
 
 	claims := &Claims{
-		Username: username,
+		Username:  username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(j.ttl)),
 		},
 	}
 
-	// Create the token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(j.signing.method, claims)
+	if j.signing.ID != "" {
+		token.Header["kid"] = j.signing.ID
+	}
 
-	// Sign the token
-	return token.SignedString(jwtSecret)
+	return token.SignedString(j.signing.sign)
 }
 
-// ParseJWT parses and validates a JWT token
-func ParseJWT(tokenString string) (*Claims, error) {
+// Parse parses and validates a JWT token. Tokens without a kid are checked with the current key.
+func (j *JWT) Parse(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		key := j.signing
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, ok = j.keys[kid]; !ok {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+			}
+		}
 
-	if !token.Valid {
-		return nil, errors.New("token is invalid")
-	}
+		// The algorithm is taken from the key, never from the token
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
 
+		return key.verify, nil
+	}, jwt.WithExpirationRequired())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
 	return claims, nil
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pemKey(t *testing.T, blockType string, der []byte) []byte {
+	t.Helper()
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func TestJWT_GenerateAndParse(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaDER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		data []byte
+		alg  string
+	}{
+		{"hmac", []byte("0123456789abcdef0123456789abcdef\n"), "HS256"},
+		{"rsa pkcs8", pemKey(t, "PRIVATE KEY", rsaDER), "RS256"},
+		{"rsa pkcs1", pemKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), "RS256"},
+		{"ed25519", pemKey(t, "PRIVATE KEY", edDER), "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey("k1", tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.alg, key.Algorithm())
+
+			j, err := NewJWT(time.Hour, key)
+			require.NoError(t, err)
+
+			token, err := j.Generate("testuser", "s1")
+			require.NoError(t, err)
+
+			claims, err := j.Parse(token)
+			require.NoError(t, err)
+			assert.Equal(t, "testuser", claims.Username)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, "k1", parsed.Header["kid"])
+			assert.Equal(t, tt.alg, parsed.Method.Alg())
+		})
+	}
+}
+
+func TestJWT_Rotation(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	require.NoError(t, err)
+
+	oldKey, err := ParseKey("old", pemKey(t, "PRIVATE KEY", privDER))
+	require.NoError(t, err)
+	old, err := NewJWT(time.Hour, oldKey)
+	require.NoError(t, err)
+	oldToken, err := old.Generate("testuser", "s1")
+	require.NoError(t, err)
+
+	// After the rotation only the public part of the old key is kept
+	oldPublic, err := ParseKey("old", pemKey(t, "PUBLIC KEY", pubDER))
+	require.NoError(t, err)
+	assert.False(t, oldPublic.CanSign())
+
+	newKey, err := NewHMACKey("new", []byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	rotated, err := NewJWT(time.Hour, newKey, oldPublic)
+	require.NoError(t, err)
+
+	claims, err := rotated.Parse(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", claims.Username)
+
+	newToken, err := rotated.Generate("testuser", "s1")
+	require.NoError(t, err)
+
+	// Once the old key is dropped its tokens are rejected
+	withoutOld, err := NewJWT(time.Hour, newKey)
+	require.NoError(t, err)
+
+	_, err = withoutOld.Parse(oldToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = withoutOld.Parse(newToken)
+	assert.NoError(t, err)
+
+	_, err = NewJWT(time.Hour, oldPublic)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = NewJWT(time.Hour, newKey, newKey)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestJWT_Parse_Invalid(t *testing.T) {
+	key, err := NewHMACKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+	j, err := NewJWT(time.Hour, key)
+	require.NoError(t, err)
+
+	t.Run("expired", func(t *testing.T) {
+		expired, err := NewJWT(-time.Minute, key)
+		require.NoError(t, err)
+		token, err := expired.Generate("testuser", "s1")
+		require.NoError(t, err)
+
+		_, err = j.Parse(token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		other, err := NewHMACKey("k1", []byte("another secret"))
+		require.NoError(t, err)
+		forged, err := NewJWT(time.Hour, other)
+		require.NoError(t, err)
+		token, err := forged.Generate("testuser", "s1")
+		require.NoError(t, err)
+
+		_, err = j.Parse(token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("algorithm none", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, &Claims{
+			Username:         "testuser",
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+
+		_, err = j.Parse(token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		_, err := j.Parse("not a token")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	_, err = ParseKey("k1", pemKey(t, "CERTIFICATE", []byte("x")))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = NewHMACKey("k1", nil)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
@@ -3,56 +3,115 @@ package auth
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"strings"
+
+	"golang.org/x/crypto/argon2"
 )
 
-// HashPassword generates a hashed password using SHA-256 (NOT recommended for production)
+// Argon2Params are the argon2id cost parameters, stored along with every hash
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id. Hashes made with
+// other parameters are upgraded on the next successful login.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// HashPassword hashes the password with argon2id. The result is in the PHC string
+// format: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
 func HashPassword(password string) (string, error) {
-	// Create a random salt (16 bytes)
-	salt := make([]byte, 16)
+	p := DefaultArgon2Params
+
+	salt := make([]byte, p.SaltLength)
 	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
 
-	// Combine password and salt
-	hash := sha256.New()
-	hash.Write(salt)
-	hash.Write([]byte(password))
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword compares the password with a stored hash in constant time. needsRehash
+// is set when the hash matches but was made with a legacy scheme or outdated parameters,
+// so the caller should store a fresh HashPassword result.
+func VerifyPassword(hashedPassword, password string) (match, needsRehash bool) {
+	if strings.HasPrefix(hashedPassword, "$argon2id$") {
+		return verifyArgon2(hashedPassword, password)
+	}
+
+	// Salted SHA-256 hashes stored before argon2id was introduced
+	return verifyLegacySHA256(hashedPassword, password), true
+}
+
+func verifyArgon2(hashedPassword, password string) (match, needsRehash bool) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+
+	var p Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return false, false
+	}
 
-	// Generate the final hash
-	hashBytes := hash.Sum(nil)
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(expected))
 
-	// Return salt and hash as a hex string
-	return fmt.Sprintf("%x$%x", salt, hashBytes), nil
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, false
+	}
+
+	return true, p != DefaultArgon2Params
 }
 
-// CheckPassword compares a hashed password with plaintext input (NOT secure)
-func CheckPassword(hashedPassword, password string) bool {
-	// Split the hashed password into salt and hash
-	parts := split(hashedPassword)
-	if len(parts) != 2 {
+// verifyLegacySHA256 checks a hash in the hex(salt)$hex(sha256(salt + password)) format
+func verifyLegacySHA256(hashedPassword, password string) bool {
+	saltHex, hashHex, ok := strings.Cut(hashedPassword, "$")
+	if !ok {
 		return false
 	}
 
-	// Convert hex-encoded salt and expected hash back to bytes
-	salt, err1 := hex.DecodeString(parts[0])
-	expectedHash, err2 := hex.DecodeString(parts[1])
+	salt, err1 := hex.DecodeString(saltHex)
+	expectedHash, err2 := hex.DecodeString(hashHex)
 	if err1 != nil || err2 != nil {
 		return false
 	}
 
-	// Hash the input password with the extracted salt
 	hash := sha256.New()
 	hash.Write(salt)
 	hash.Write([]byte(password))
 
-	// Compare the generated hash with the stored one
-	return string(hash.Sum(nil)) == string(expectedHash)
-}
-
-// Helper function to split the salt and hash from the formatted string
-func split(s string) []string {
-	return strings.Split(s, "$")
+	return subtle.ConstantTimeCompare(hash.Sum(nil), expectedHash) == 1
 }
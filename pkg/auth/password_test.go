@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPassword(t *testing.T) {
+	hashed, err := HashPassword("password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hashed, "$argon2id$v=19$m=19456,t=2,p=1$"), hashed)
+
+	again, err := HashPassword("password")
+	require.NoError(t, err)
+	assert.NotEqual(t, hashed, again, "every hash has its own salt")
+
+	match, needsRehash := VerifyPassword(hashed, "password")
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+
+	match, _ = VerifyPassword(hashed, "Password")
+	assert.False(t, match)
+}
+
+func TestVerifyPassword_OutdatedParams(t *testing.T) {
+	defaults := DefaultArgon2Params
+	DefaultArgon2Params.Iterations = 1
+	hashed, err := HashPassword("password")
+	DefaultArgon2Params = defaults
+	require.NoError(t, err)
+
+	match, needsRehash := VerifyPassword(hashed, "password")
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+}
+
+func TestVerifyPassword_Legacy(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	sum := sha256.Sum256(append(append([]byte{}, salt...), "password"...))
+	legacy := hex.EncodeToString(salt) + "$" + hex.EncodeToString(sum[:])
+
+	match, needsRehash := VerifyPassword(legacy, "password")
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	match, _ = VerifyPassword(legacy, "wrong")
+	assert.False(t, match)
+}
+
+func TestVerifyPassword_Malformed(t *testing.T) {
+	for _, hashed := range []string{
+		"",
+		"not a hash",
+		"$argon2id$v=19$m=19456,t=2,p=1$salt",
+		"$argon2id$v=18$m=19456,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=19456,t=2,p=1$!!!$aGFzaA",
+		"zz$zz",
+	} {
+		match, _ := VerifyPassword(hashed, "password")
+		assert.False(t, match, hashed)
+	}
+}
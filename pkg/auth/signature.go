@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+)
+
+// signaturePrefix is an optional prefix of the signature header value
+const signaturePrefix = "sha256="
+
+// Sign returns the hex encoded HMAC-SHA256 of timestamp and body. The timestamp is signed
+// along with the body, so that a captured request can't be replayed with a fresh one.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	return hex.EncodeToString(signedPayload(secret, timestamp, body).Sum(nil))
+}
+
+// VerifySignature checks in constant time that signature is the HMAC-SHA256 of timestamp
+// and body
+func VerifySignature(secret []byte, timestamp string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(signedPayload(secret, timestamp, body).Sum(nil), expected)
+}
+
+// signedPayload separates the timestamp from the body, so that digits can't be moved
+// between them
+func signedPayload(secret []byte, timestamp string, body []byte) hash.Hash {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac
+}
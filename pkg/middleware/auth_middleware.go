@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"strings"
 
@@ -10,9 +11,34 @@ import (
 
 type contextKey string
 
-const UserContextKey contextKey = "user"
+const (
+	UserContextKey    contextKey = "user"
+	SessionContextKey contextKey = "session"
+)
+
+type TokenParser interface {
+	Parse(tokenString string) (*auth.Claims, error)
+}
+
+type SessionChecker interface {
+	Active(ctx context.Context, sessionID string) (bool, error)
+}
+
+type Auth struct {
+	tokens   TokenParser
+	sessions SessionChecker
+}
+
+func NewAuth(tokens TokenParser, sessions SessionChecker) *Auth {
+	return &Auth{
+		tokens:   tokens,
+		sessions: sessions,
+	}
+}
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// Handler puts the user and the session of the bearer token into the request context
+// and rejects requests without a valid token or whose session has been revoked
+func (a *Auth) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 
@@ -23,13 +49,25 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		claims, err := auth.ParseJWT(tokenString)
+		claims, err := a.tokens.Parse(tokenString)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		active, err := a.sessions.Active(r.Context(), claims.SessionID)
 		if err != nil {
+			slog.Error("Failed to check session", slog.String("error", err.Error()))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !active {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), UserContextKey, claims.Username)
+		ctx = context.WithValue(ctx, SessionContextKey, claims.SessionID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
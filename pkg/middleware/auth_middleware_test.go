@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atinyakov/go-musthave-diploma/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type tokensFunc func(string) (*auth.Claims, error)
+
+func (f tokensFunc) Parse(token string) (*auth.Claims, error) { return f(token) }
+
+type sessionsFunc func(string) (bool, error)
+
+func (f sessionsFunc) Active(_ context.Context, id string) (bool, error) { return f(id) }
+
+func TestAuth_Handler(t *testing.T) {
+	tokens := tokensFunc(func(token string) (*auth.Claims, error) {
+		if token != "valid" {
+			return nil, auth.ErrInvalidToken
+		}
+		return &auth.Claims{Username: "testuser", SessionID: "s1"}, nil
+	})
+
+	var user, session string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = r.Context().Value(UserContextKey).(string)
+		session, _ = r.Context().Value(SessionContextKey).(string)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		active bool
+		err    error
+		want   int
+	}{
+		{"active session", "Bearer valid", true, nil, http.StatusOK},
+		{"revoked session", "Bearer valid", false, nil, http.StatusUnauthorized},
+		{"session check fails", "Bearer valid", false, errors.New("db is down"), http.StatusInternalServerError},
+		{"invalid token", "Bearer forged", true, nil, http.StatusUnauthorized},
+		{"no token", "", true, nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, session = "", ""
+			h := NewAuth(tokens, sessionsFunc(func(id string) (bool, error) {
+				assert.Equal(t, "s1", id)
+				return tt.active, tt.err
+			})).Handler(next)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				assert.Equal(t, "testuser", user)
+				assert.Equal(t, "s1", session)
+			} else {
+				assert.Empty(t, user)
+			}
+		})
+	}
+}